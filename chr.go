@@ -7,6 +7,9 @@ import (
 
 type RawChr struct {
 	r io.ReadSeeker
+
+	// Order of the bit planes in each tile.  Defaults to CF_Nes.
+	Format ChrFormat
 }
 
 func NewRawChr(r io.ReadSeeker) *RawChr {
@@ -19,29 +22,13 @@ func (raw *RawChr) ReadTile(depth BitDepth) (*Tile, error) {
 		return nil, err
 	}
 
-	planes := [][]byte{}
-	for i := 0; i < planeCount; i++ {
-		buff := make([]byte, 8)
-		n, err := io.ReadFull(raw.r, buff)
-		if err != nil {
-
-			if errors.Is(err, io.EOF) && i == 0 {
-				return nil, io.EOF
-			} else if errors.Is(err, io.EOF) {
-				return nil, io.ErrUnexpectedEOF
-			}
-
-			return nil, err
-		}
-
-		if n != 8 {
-			return nil, errors.New("Plane didn't read eight bytes")
-		}
-
-		planes = append(planes, buff)
+	buff := make([]byte, planeCount*8)
+	_, err = io.ReadFull(raw.r, buff)
+	if err != nil {
+		return nil, err
 	}
 
-	return NewTileFromPlanes(planes)
+	return raw.Format.Decode(buff, depth)
 }
 
 func (raw *RawChr) ReadAllTiles(depth BitDepth) ([]*Tile, error) {
@@ -0,0 +1,101 @@
+package retroimg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChrFormat is the order the bit planes of a tile are stored in.
+type ChrFormat int
+
+const (
+	// Each plane is stored as eight bytes, one after the other.
+	CF_Nes ChrFormat = iota
+
+	// Planes are stored in pairs with the rows of each pair interleaved.
+	// Higher bit depths repeat this for every pair of planes.
+	CF_Snes
+
+	// Same layout as CF_Snes.  Only 2bpp is valid on hardware.
+	CF_Gb
+)
+
+func (cf ChrFormat) interleaved() bool {
+	return cf == CF_Snes || cf == CF_Gb
+}
+
+// Decode reads a single tile from data.  data must be exactly one tile long.
+func (cf ChrFormat) Decode(data []byte, depth BitDepth) (*Tile, error) {
+	planeCount, err := depth.PlaneCount()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != planeCount*8 {
+		return nil, fmt.Errorf("tile data is %d bytes; expected %d", len(data), planeCount*8)
+	}
+
+	planes := make([][]byte, planeCount)
+	for p := 0; p < planeCount; p++ {
+		planes[p] = make([]byte, 8)
+		for y := 0; y < 8; y++ {
+			planes[p][y] = data[cf.offset(p, y, planeCount)]
+		}
+	}
+
+	return NewTileFromPlanes(planes)
+}
+
+// Encode returns the bit planes of tile in this format.  The number of planes
+// is determined by Tile.Depth.
+func (cf ChrFormat) Encode(tile *Tile) []byte {
+	planar := tile.binary()
+	if !cf.interleaved() {
+		return planar
+	}
+
+	planeCount := len(planar) / 8
+	data := make([]byte, len(planar))
+	for p := 0; p < planeCount; p++ {
+		for y := 0; y < 8; y++ {
+			data[cf.offset(p, y, planeCount)] = planar[p*8+y]
+		}
+	}
+	return data
+}
+
+// offset of row y of plane p in a tile's data.
+func (cf ChrFormat) offset(p, y, planeCount int) int {
+	if !cf.interleaved() || planeCount == 1 {
+		return p*8 + y
+	}
+	return (p/2)*16 + y*2 + p%2
+}
+
+func (cf *ChrFormat) UnmarshalText(b []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "nes":
+		*cf = CF_Nes
+	case "snes":
+		*cf = CF_Snes
+	case "gb", "gbc":
+		*cf = CF_Gb
+	default:
+		return fmt.Errorf("Invalid CHR format: %q", string(b))
+	}
+
+	return nil
+}
+
+func (cf ChrFormat) String() string {
+	switch cf {
+	case CF_Nes:
+		return "CF_Nes"
+	case CF_Snes:
+		return "CF_Snes"
+	case CF_Gb:
+		return "CF_Gb"
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,236 @@
+package retroimg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PackState is a game state, eg. a title screen or a level, and every tile
+// it needs loaded at the same time.
+type PackState struct {
+	Name  string
+	Tiles TileList
+}
+
+// ChrPack is the result of PackChrBanks.
+type ChrPack struct {
+	Banks []TileList
+
+	// The banks each state has loaded, in the order they are mapped.
+	StateBanks [][]int
+
+	tilesPerBank int
+	maxBanks     int
+
+	// Tiles of each state that haven't been placed yet.
+	remaining []int
+
+	// Bank and index in the bank of each tile, by hash.
+	location map[string][2]int
+}
+
+// packGroup is the tiles used by exactly the same set of states.
+type packGroup struct {
+	states []int
+	tiles  TileList
+}
+
+// PackChrBanks assigns the tiles of every state to banks of tilesPerBank
+// tiles, eg. 64 for 1K banks of 2bpp tiles.  No state may need more than
+// maxBanks banks at once.  States with the most tiles are packed first.
+// Within a state, tiles shared by the most states are placed first, and
+// filled into existing banks before new ones are started, to keep the total
+// number of banks down.
+func PackChrBanks(states []PackState, tilesPerBank, maxBanks int) (*ChrPack, error) {
+	if tilesPerBank <= 0 || maxBanks <= 0 {
+		return nil, fmt.Errorf("Invalid bank layout: %d tiles in %d banks", tilesPerBank, maxBanks)
+	}
+
+	pack := &ChrPack{
+		StateBanks:   make([][]int, len(states)),
+		tilesPerBank: tilesPerBank,
+		maxBanks:     maxBanks,
+		remaining:    make([]int, len(states)),
+		location:     map[string][2]int{},
+	}
+
+	tiles := map[string]*Tile{}
+	users := map[string][]int{}
+	stateTiles := make([][]string, len(states))
+
+	for si, state := range states {
+		seen := map[string]bool{}
+		for _, tile := range state.Tiles {
+			h := tile.Hash()
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+
+			tiles[h] = tile
+			users[h] = append(users[h], si)
+			stateTiles[si] = append(stateTiles[si], h)
+		}
+
+		if len(seen) > tilesPerBank*maxBanks {
+			return nil, fmt.Errorf("%s has %d unique tiles; only %d fit in %d banks", state.Name, len(seen), tilesPerBank*maxBanks, maxBanks)
+		}
+		pack.remaining[si] = len(seen)
+	}
+
+	order := []int{}
+	for si := range states {
+		order = append(order, si)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(stateTiles[order[i]]) > len(stateTiles[order[j]])
+	})
+
+	for _, si := range order {
+		groups := []*packGroup{}
+		byUsers := map[string]*packGroup{}
+		for _, h := range stateTiles[si] {
+			if _, placed := pack.location[h]; placed {
+				continue
+			}
+
+			key := fmt.Sprint(users[h])
+			g, ok := byUsers[key]
+			if !ok {
+				g = &packGroup{states: users[h]}
+				byUsers[key] = g
+				groups = append(groups, g)
+			}
+			g.tiles = append(g.tiles, tiles[h])
+		}
+
+		sort.SliceStable(groups, func(i, j int) bool {
+			if len(groups[i].states) != len(groups[j].states) {
+				return len(groups[i].states) > len(groups[j].states)
+			}
+			return len(groups[i].tiles) > len(groups[j].tiles)
+		})
+
+		for _, g := range groups {
+			err := pack.place(g, states)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, banks := range pack.StateBanks {
+		sort.Ints(banks)
+	}
+
+	return pack, nil
+}
+
+// place puts the group's tiles in existing banks where it can, and new banks
+// where it can't.
+func (cp *ChrPack) place(g *packGroup, states []PackState) error {
+	for len(g.tiles) > 0 {
+		bank := cp.pickBank(g.states, len(g.tiles))
+		if bank < 0 {
+			for _, s := range g.states {
+				if len(cp.StateBanks[s]) >= cp.maxBanks {
+					return fmt.Errorf("%s needs more than %d banks", states[s].Name, cp.maxBanks)
+				}
+			}
+
+			cp.Banks = append(cp.Banks, TileList{})
+			bank = len(cp.Banks) - 1
+		}
+
+		n := min(cp.tilesPerBank-len(cp.Banks[bank]), len(g.tiles))
+		for _, tile := range g.tiles[:n] {
+			cp.location[tile.Hash()] = [2]int{bank, len(cp.Banks[bank])}
+			cp.Banks[bank] = append(cp.Banks[bank], tile)
+		}
+		g.tiles = g.tiles[n:]
+
+		for _, s := range g.states {
+			cp.remaining[s] -= n
+			if !containsInt(cp.StateBanks[s], bank) {
+				cp.StateBanks[s] = append(cp.StateBanks[s], bank)
+			}
+		}
+	}
+
+	return nil
+}
+
+// free is the number of empty tile slots in the banks.
+func (cp *ChrPack) free(banks []int) int {
+	n := 0
+	for _, b := range banks {
+		n += cp.tilesPerBank - len(cp.Banks[b])
+	}
+	return n
+}
+
+// pickBank returns the existing bank with room that the fewest of the states
+// don't have loaded yet, or -1 if there isn't one.  A state only takes on a
+// partly full bank if its remaining tiles will still fit in the banks it has
+// left.  Ties go to the fullest bank.
+func (cp *ChrPack) pickBank(states []int, count int) int {
+	best, bestAdded := -1, 0
+	for bank, tiles := range cp.Banks {
+		if len(tiles) >= cp.tilesPerBank {
+			continue
+		}
+		n := min(cp.tilesPerBank-len(tiles), count)
+
+		added := 0
+		fits := true
+		for _, s := range states {
+			if containsInt(cp.StateBanks[s], bank) {
+				continue
+			}
+
+			left := cp.maxBanks - len(cp.StateBanks[s]) - 1
+			room := left*cp.tilesPerBank + cp.free(cp.StateBanks[s]) + cp.free([]int{bank}) - n
+			if left < 0 || cp.remaining[s]-n > room {
+				fits = false
+				break
+			}
+			added++
+		}
+
+		if !fits {
+			continue
+		}
+
+		if best < 0 || added < bestAdded || (added == bestAdded && len(tiles) > len(cp.Banks[best])) {
+			best, bestAdded = bank, added
+		}
+	}
+
+	return best
+}
+
+// TileId returns the ID of tile while the banks of state are mapped in
+// order, starting at ID zero.
+func (cp *ChrPack) TileId(state int, tile *Tile) (int, error) {
+	loc, ok := cp.location[tile.Hash()]
+	if !ok {
+		return 0, fmt.Errorf("tile %s was not packed", tile.Hash())
+	}
+
+	for slot, bank := range cp.StateBanks[state] {
+		if bank == loc[0] {
+			return slot*cp.tilesPerBank + loc[1], nil
+		}
+	}
+
+	return 0, fmt.Errorf("tile %s is in bank %d which isn't loaded", tile.Hash(), loc[0])
+}
+
+func containsInt(list []int, val int) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+
+	"github.com/zorchenhimer/go-retroimg/patch"
+)
+
+type Arguments struct {
+	Input  string `arg:"positional,required" help:"Unmodified ROM"`
+	Patch  string `arg:"positional,required" help:"IPS or BPS patch"`
+	Output string `arg:"positional,required"`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	original, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	p, err := os.ReadFile(args.Patch)
+	if err != nil {
+		return err
+	}
+
+	patched, err := patch.Apply(original, p)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(args.Output, patched, 0644)
+}
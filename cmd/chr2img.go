@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"image"
 	"image/color"
 	"image/png"
 	"image/jpeg"
@@ -15,7 +17,9 @@ import (
 	"github.com/alexflint/go-arg"
 
 	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/compress"
 	"github.com/zorchenhimer/go-retroimg/palette"
+	"github.com/zorchenhimer/go-retroimg/rom"
 )
 
 type Arguments struct {
@@ -30,9 +34,24 @@ type Arguments struct {
 
 	// --nes-pal 0F,00,1A,20
 	NesPal string `arg:"--nes-pal"`
+	NesPalFile string `arg:"--nes-pal-file" help:"64 color .pal file used to look up --nes-pal colors instead of the built in 2C02 colors."`
 
 	PaletteFile string `arg:"--pal-file" help:"Read palette colors from this text file.  One color per line in HTML color syntax (eg #00AA55)."`
 
+	// --gb-pal green --gb-reg 0xE4
+	GbPal  string `arg:"--gb-pal" help:"Preview with DMG shades. Accepted values are green, pocket, & bgb."`
+	GbReg  string `arg:"--gb-reg" default:"0xE4" help:"BGP, OBP0, or OBP1 register value used with --gb-pal."`
+	GbcPal string `arg:"--gbc-pal" help:"Four comma separated HTML colors, snapped to the colors a GBC can display."`
+
+	Format snesimg.ChrFormat `arg:"--format" default:"nes" help:"Order of the bit planes in the input. Accepted values are nes, snes, & gb."`
+
+	Transparent bool `arg:"--transparent" help:"Render color index 0 as transparent.  Only PNG and GIF output keep the transparency."`
+
+	Decompress string `arg:"--decompress" help:"Decompress the input, starting at --start, before reading tiles.  Accepted values are donut, lz4, lzss, gba-lz77, gba-rle, gba-huffman, lc-lz2, lc-lz3, konami, neslib, packbits, & pokemon-gen1."`
+
+	// Input files with an iNES header have all of their CHR-ROM banks dumped
+	// unless --start or --tile-count are given.  Use {bank} in Output to
+	// place the bank number in the filename.
 	StartOffset string `arg:"--start"`
 	startOffset int
 	TileCount string `arg:"--tile-count"`
@@ -53,8 +72,15 @@ func run(args *Arguments) error {
 	var pal color.Palette
 	var err error
 
-	if args.PaletteFile != "" && args.NesPal != "" {
-		return fmt.Errorf("Cannot use both --nes-pal and --pal-file")
+	palCount := 0
+	for _, p := range []string{args.PaletteFile, args.NesPal, args.GbPal, args.GbcPal} {
+		if p != "" {
+			palCount++
+		}
+	}
+
+	if palCount > 1 {
+		return fmt.Errorf("Only one of --pal-file, --nes-pal, --gb-pal, and --gbc-pal can be used")
 	}
 
 	if args.PaletteFile != "" {
@@ -80,9 +106,45 @@ func run(args *Arguments) error {
 			parts[i] = strings.TrimLeft(parts[i], "$")
 		}
 
-		pal = palette.Nes_2C02.NesPalette(parts[0], parts[1], parts[2], parts[3])
+		cm := palette.Nes_2C02
+		if args.NesPalFile != "" {
+			full, err := palette.FromFile(args.NesPalFile, palette.PF_RawRGB)
+			if err != nil {
+				return err
+			}
+			cm = palette.NewColorMap(full)
+		}
+
+		pal = cm.NesPalette(parts[0], parts[1], parts[2], parts[3])
 		fmt.Println(pal)
 
+	} else if args.GbPal != "" {
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --gb-pal with a 2bpp image")
+		}
+
+		tint, err := palette.DmgTint(args.GbPal)
+		if err != nil {
+			return err
+		}
+
+		reg, err := strconv.ParseUint(args.GbReg, 0, 8)
+		if err != nil {
+			return fmt.Errorf("Invalid --gb-reg value: %w", err)
+		}
+
+		pal = palette.DmgPalette(tint, uint8(reg))
+
+	} else if args.GbcPal != "" {
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --gbc-pal with a 2bpp image")
+		}
+
+		pal, err = palette.ParseGbcPalette(args.GbcPal)
+		if err != nil {
+			return err
+		}
+
 	} else {
 		pal, err = args.BitDepth.DefaultPalette()
 		if err != nil {
@@ -102,12 +164,28 @@ func run(args *Arguments) error {
 		pal = pal[:numColors]
 	}
 
+	if args.Transparent {
+		// Copy it so the default palettes aren't modified.
+		pal = append(color.Palette{color.RGBA{0, 0, 0, 0}}, pal[1:]...)
+	}
+
 	input, err := os.Open(args.Input)
 	if err != nil {
 		return err
 	}
 	defer input.Close()
 
+	if args.StartOffset == "" && args.TileCount == "" && args.Decompress == "" {
+		romMap, err := rom.Detect(input)
+		if err != nil {
+			return err
+		}
+
+		if ines, ok := romMap.(*rom.INes); ok {
+			return dumpChrBanks(args, input, ines, pal, numColors)
+		}
+	}
+
 	if args.StartOffset != "" {
 		offset, err := strconv.ParseInt(args.StartOffset, 0, 32)
 		if err != nil {
@@ -120,7 +198,16 @@ func run(args *Arguments) error {
 		}
 	}
 
-	raw := snesimg.NewRawChr(input)
+	var chrInput io.ReadSeeker = input
+	if args.Decompress != "" {
+		chrInput, err = decompressInput(input, args.Decompress)
+		if err != nil {
+			return err
+		}
+	}
+
+	raw := snesimg.NewRawChr(chrInput)
+	raw.Format = args.Format
 
 	var tiles []*snesimg.Tile
 	if args.TileCount != "" {
@@ -156,13 +243,95 @@ func run(args *Arguments) error {
 	args.Output = strings.ReplaceAll(args.Output, "{bpp}", args.BitDepth.String())
 	fmt.Println("output:", args.Output)
 
-	output, err := os.Create(args.Output)
+	return writeImage(args.Output, img, numColors)
+}
+
+func dumpChrBanks(args *Arguments, input io.ReadSeeker, ines *rom.INes, pal color.Palette, numColors int) error {
+	if ines.ChrSize == 0 {
+		return fmt.Errorf("ROM has no CHR-ROM (mapper %d uses CHR-RAM)", ines.Mapper)
+	}
+
+	planeCount, err := args.BitDepth.PlaneCount()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("mapper: %d; PRG: %dK; CHR: %dK; mirroring: %s\n",
+		ines.Mapper, ines.PrgSize/1024, ines.ChrSize/1024, ines.Mirroring)
+
+	raw := snesimg.NewRawChr(input)
+	raw.Format = args.Format
+
+	for bank := 0; bank < ines.ChrBanks(); bank++ {
+		offset := ines.ChrOffset() + int64(bank*rom.ChrBankSize)
+		_, err = input.Seek(offset, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("Seek() error: %w", err)
+		}
+
+		tiles := []*snesimg.Tile{}
+		for i := 0; i < rom.ChrBankSize/(planeCount*8); i++ {
+			t, err := raw.ReadTile(args.BitDepth)
+			if err != nil {
+				fmt.Printf("read tile err: %s\n", err)
+				break
+			}
+			tiles = append(tiles, t)
+		}
+
+		img := snesimg.NewTiledImageFromTiles(args.BitDepth, pal, tiles)
+
+		outname := args.Output
+		if !strings.Contains(outname, "{bank}") {
+			ext := filepath.Ext(outname)
+			outname = strings.TrimSuffix(outname, ext) + "_{bank}" + ext
+		}
+
+		outname = strings.ReplaceAll(outname, "{bank}", fmt.Sprintf("%02d", bank))
+		outname = strings.ReplaceAll(outname, "{start}", fmt.Sprintf("0x%X", offset))
+		outname = strings.ReplaceAll(outname, "{count}", strconv.Itoa(len(tiles)))
+		outname = strings.ReplaceAll(outname, "{bpp}", args.BitDepth.String())
+		fmt.Println("output:", outname)
+
+		err = writeImage(outname, img, numColors)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decompressInput decompresses everything from the current position of
+// input onward.
+func decompressInput(input io.Reader, name string) (io.ReadSeeker, error) {
+	codec, err := compress.ByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	data, n, err := codec.Decompress(packed)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("%s: %d bytes decompressed to %d\n", codec.Name(), n, len(data))
+	return bytes.NewReader(data), nil
+}
+
+func writeImage(filename string, img image.Image, numColors int) error {
+	output, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer output.Close()
 
-	switch strings.ToLower(filepath.Ext(args.Output)) {
+	switch strings.ToLower(filepath.Ext(filename)) {
 	case ".png":
 		err = png.Encode(output, img)
 	case ".jpg", ".jpeg":
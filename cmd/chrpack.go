@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/export"
+)
+
+type Arguments struct {
+	Config     string `arg:"positional,required" help:"JSON file listing the assets and the states that use them."`
+	OutputBase string `arg:"positional,required" help:"Banks are written to OutputBase_N.chr and the tile mapping to OutputBase.json."`
+
+	BankSize string `arg:"--bank-size" default:"1k" help:"Size of each CHR bank, eg. 1k or 2k."`
+	Banks    int    `arg:"--banks" help:"Banks each state can have mapped at once.  Defaults to one 4K pattern table's worth."`
+
+	BitDepth snesimg.BitDepth  `arg:"--bit-depth,-d" default:"2" help:"Bits per pixel. Accepted values are 1, 2, 4, & 8 or 1bpp, 2bpp, 4bpp, & 8bpp."`
+	Format   snesimg.ChrFormat `arg:"--format" default:"nes" help:"Order of the bit planes in the output. Accepted values are nes, snes, & gb."`
+}
+
+// Config lists images of tiles, eg. screens or sprite sets, and the game
+// states that need them loaded at the same time.  Files are relative to the
+// config.
+//
+//	{
+//		"Assets": [{"Name": "title", "File": "title.png"}, ...],
+//		"States": [{"Name": "menu", "Assets": ["title", "font"]}, ...]
+//	}
+type Config struct {
+	Assets []ConfigAsset
+	States []ConfigState
+}
+
+type ConfigAsset struct {
+	Name string
+	File string
+}
+
+type ConfigState struct {
+	Name   string
+	Assets []string
+}
+
+// StateMap is written for each state.  Tiles holds the tile IDs of each of
+// the state's assets, in the order the tiles appear in the asset's image.
+type StateMap struct {
+	Name  string
+	Banks []int
+	Tiles map[string][]int
+}
+
+type PackMap struct {
+	BankSize     int
+	TilesPerBank int
+	States       []StateMap
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	bankSize, err := export.ParseSize(args.BankSize)
+	if err != nil {
+		return err
+	}
+
+	maxBanks := args.Banks
+	if maxBanks == 0 {
+		maxBanks = 0x1000 / bankSize
+	}
+
+	file, err := os.Open(args.Config)
+	if err != nil {
+		return err
+	}
+
+	cfg := Config{}
+	err = json.NewDecoder(file).Decode(&cfg)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", args.Config, err)
+	}
+
+	pal, err := args.BitDepth.DefaultPalette()
+	if err != nil {
+		return err
+	}
+
+	assets := map[string]*snesimg.TiledImage{}
+	for _, a := range cfg.Assets {
+		ti, err := loadAsset(filepath.Join(filepath.Dir(args.Config), a.File), args.BitDepth, pal)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Name, err)
+		}
+		assets[a.Name] = ti
+	}
+
+	tileSize := 0
+	states := []snesimg.PackState{}
+	for _, s := range cfg.States {
+		state := snesimg.PackState{Name: s.Name}
+		for _, name := range s.Assets {
+			ti, ok := assets[name]
+			if !ok {
+				return fmt.Errorf("%s: unknown asset %q", s.Name, name)
+			}
+
+			unique := ti.UniqueTiles()
+			state.Tiles = append(state.Tiles, unique...)
+			if tileSize == 0 && len(unique) > 0 {
+				tileSize = len(args.Format.Encode(unique[0]))
+			}
+		}
+		states = append(states, state)
+	}
+
+	if tileSize == 0 {
+		return fmt.Errorf("No tiles to pack")
+	}
+
+	if bankSize%tileSize != 0 {
+		return fmt.Errorf("Bank size %d isn't a multiple of the %d byte tile size", bankSize, tileSize)
+	}
+
+	pack, err := snesimg.PackChrBanks(states, bankSize/tileSize, maxBanks)
+	if err != nil {
+		return err
+	}
+
+	for i, bank := range pack.Banks {
+		buf := &bytes.Buffer{}
+		err = bank.WriteChrFormat(buf, args.Format)
+		if err != nil {
+			return err
+		}
+
+		filename := fmt.Sprintf("%s_%d.chr", args.OutputBase, i)
+		fmt.Printf("%s: %d tiles\n", filename, len(bank))
+
+		// Pad out to the full bank.
+		data := append(buf.Bytes(), make([]byte, bankSize-buf.Len())...)
+		err = os.WriteFile(filename, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	packMap := PackMap{
+		BankSize:     bankSize,
+		TilesPerBank: bankSize / tileSize,
+	}
+
+	for i, s := range cfg.States {
+		sm := StateMap{
+			Name:  s.Name,
+			Banks: pack.StateBanks[i],
+			Tiles: map[string][]int{},
+		}
+
+		for _, name := range s.Assets {
+			ids := []int{}
+			for _, tile := range assets[name].Tiles {
+				id, err := pack.TileId(i, tile)
+				if err != nil {
+					return fmt.Errorf("%s: %w", s.Name, err)
+				}
+				ids = append(ids, id)
+			}
+			sm.Tiles[name] = ids
+		}
+
+		fmt.Printf("%s: banks %v\n", s.Name, sm.Banks)
+		packMap.States = append(packMap.States, sm)
+	}
+
+	raw, err := json.MarshalIndent(packMap, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(args.OutputBase+".json", raw, 0644)
+}
+
+func loadAsset(filename string, depth snesimg.BitDepth, pal color.Palette) (*snesimg.TiledImage, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return snesimg.NewTiledImageFromImage(snesimg.CS_8x8, depth, pal, img)
+}
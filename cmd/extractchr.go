@@ -1,19 +1,17 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"image/png"
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 
 	"github.com/alexflint/go-arg"
 
 	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/rom"
 )
 
 type Arguments struct {
@@ -33,178 +31,29 @@ func main() {
 	}
 }
 
-type Segment struct {
-	Start int
-	Depth snesimg.BitDepth
-	Count int
-	Name string
-
-	// In tiles, not pixels
-	Width int
-	Height int
-
-	TileOrder  []int
-	Sequential bool
-
-	// Stride in Metatiles for the output image
-	Stride int
-}
-
-func (s Segment) String() string {
-	return fmt.Sprintf("{Segment Start:0x%X Count:0x%X (%d) Depth:%s TileOrder:%v}",
-		s.Start,
-		s.Count,
-		s.Count,
-		s.Depth,
-		s.TileOrder,
-	)
-}
-
-type CfgSegment struct {
-	Start string
-	Depth int
-	Count string
-	Name  string
-	Dimensions string // WxH: 1x1, 2x1, 1x3, 2x2, etc
-	TileOrder string // comma separated list of numbers
-	Sequential bool
-	Stride int // metatile count
-}
-
-func parseConfig(filename string) ([]Segment, error) {
-	file, err := os.Open(filename)
+func run(args *Arguments) error {
+	romfile, err := os.Open(args.Input)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
+	defer romfile.Close()
 
-	cfg := []CfgSegment{}
-	dec := json.NewDecoder(file)
-	err = dec.Decode(&cfg)
+	romMap, err := rom.Detect(romfile)
 	if err != nil {
-		return nil, err
-	}
-
-	segments := []Segment{}
-	for _, seg := range cfg {
-		start, err := strconv.ParseInt(seg.Start, 0, 32)
-		if err != nil {
-			return nil, err
-		}
-
-		count, err := strconv.ParseInt(seg.Count, 0, 32)
-		if err != nil {
-			return nil, err
-		}
-
-		w, h := 1, 1
-
-		if seg.Dimensions != "" {
-			if !strings.Contains(seg.Dimensions, "x") {
-				return nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
-			}
-
-			dims := strings.Split(seg.Dimensions, "x")
-			if len(dims) != 2 {
-				return nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
-			}
-
-			w64, err := strconv.ParseInt(dims[0], 0, 32)
-			if err != nil {
-				return nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
-			}
-
-			h64, err := strconv.ParseInt(dims[1], 0, 32)
-			if err != nil {
-				return nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
-			}
-
-			w, h = int(w64), int(h64)
-		}
-
-		tileCount := w*h
-		tileOrder := []int{}
-
-		if seg.TileOrder == "" {
-			for i := 0; i < tileCount; i++ {
-				tileOrder = append(tileOrder, i+1)
-			}
-		} else {
-			orderNums := strings.Split(seg.TileOrder, ",")
-			highest := 0
-
-			for _, str := range orderNums {
-				n, err := strconv.Atoi(str)
-				if err != nil {
-					return nil, fmt.Errorf("Invalid TileOrder %q: %w", seg.TileOrder, err)
-				}
-
-				if n > highest {
-					highest = n
-				}
-				tileOrder = append(tileOrder, n)
-			}
-
-			// FIXME: do i care about repeated numbers?
-			if highest != len(tileOrder) {
-				return nil, fmt.Errorf("Invalid TileOrder %q: bad length", seg.TileOrder)
-			}
-		}
-
-		depth := snesimg.BD_2bpp
-		if seg.Depth == 1 {
-			depth = snesimg.BD_1bpp
-		}
-
-		if count < 1 {
-			fmt.Println("Ignoring segment at", seg.Start)
-			continue
-		}
-
-		seg.Name = strings.ReplaceAll(seg.Name, "{start}", seg.Start)
-		seg.Name = strings.ReplaceAll(seg.Name, "{count}", seg.Count)
-		seg.Name = strings.ReplaceAll(seg.Name, "{bpp}", strconv.Itoa(seg.Depth))
-
-		if seg.Stride == 0 {
-			seg.Stride = 16
-		}
-
-		segments = append(segments, Segment{
-			Start: int(start),
-			Depth: depth,
-			Count: int(count),
-			Name: seg.Name,
-			Width: w,
-			Height: h,
-			TileOrder: tileOrder,
-			Sequential: seg.Sequential,
-			Stride: seg.Stride,
-		})
+		return err
 	}
 
-	return segments, nil
-}
-
-func run(args *Arguments) error {
-	segments, err := parseConfig(args.Config)
+	segments, warnings, err := snesimg.ParseSegmentConfig(args.Config, romMap)
 	if err != nil {
 		return err
 	}
 
-	romfile, err := os.Open(args.Input)
-	if err != nil {
-		return err
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
 	}
-	defer romfile.Close()
-	raw := snesimg.NewRawChr(romfile)
 
 	if args.OutDir == "" {
-		idx := strings.LastIndex(args.Input, ".")
-		if idx < 1 {
-			args.OutDir = args.Input+"_output"
-		} else {
-			args.OutDir = args.Input[:idx]
-		}
+		args.OutDir = snesimg.SegmentDir(args.Input)
 	}
 
 	err = os.MkdirAll(args.OutDir, 0755)
@@ -213,24 +62,35 @@ func run(args *Arguments) error {
 	}
 
 	for num, seg := range segments {
-		outname := fmt.Sprintf("%04d_%05X.png", num, seg.Start)
-		if seg.Name != "" {
-			outname = seg.Name
-		}
+		outname := seg.Filename(num)
 
 		fmt.Println(outname, seg)
 
-		_, err = romfile.Seek(int64(seg.Start), io.SeekStart)
+		depth := snesimg.BitDepth(seg.Depth)
+		pal, err := depth.DefaultPalette()
 		if err != nil {
-			return fmt.Errorf("seek error (%05X): %w", seg.Start, err)
+			return err
 		}
 
-		depth := snesimg.BitDepth(seg.Depth)
-		pal, err := depth.DefaultPalette()
+		if seg.Palette >= 0 {
+			pal, err = seg.ReadPalette(romfile, len(pal))
+			if err != nil {
+				return fmt.Errorf("palette at 0x%X: %w", seg.Palette, err)
+			}
+		}
+
+		chr, consumed, err := seg.Open(romfile)
 		if err != nil {
 			return err
 		}
 
+		if seg.Compression != nil {
+			fmt.Printf("%s: 0x%X bytes at 0x%X-0x%X\n", seg.Compression.Name(), consumed, seg.Start, seg.Start+consumed-1)
+		}
+
+		raw := snesimg.NewRawChr(chr)
+		raw.Format = seg.Format
+
 		tilesPerTile := seg.Width * seg.Height
 
 		MetaImage := &snesimg.MetaImage{
@@ -250,6 +110,7 @@ func run(args *Arguments) error {
 					}
 					return err
 				}
+				tile.Palette = pal
 				tiles = append(tiles, tile)
 			}
 
@@ -264,10 +125,13 @@ func run(args *Arguments) error {
 			MetaImage.MetaTiles = append(MetaImage.MetaTiles, mt)
 		}
 
-		if len(MetaImage.MetaTiles) < MetaImage.Stride {
-			MetaImage.Stride = len(MetaImage.MetaTiles)
+		if len(MetaImage.MetaTiles) == 0 {
+			fmt.Printf("WARN: skipping %s; no whole metatiles at 0x%X\n", outname, seg.Start)
+			continue
 		}
 
+		MetaImage.Stride = snesimg.ImageStride(MetaImage.Stride, len(MetaImage.MetaTiles))
+
 		output, err := os.Create(filepath.Join(args.OutDir, outname))
 		if err != nil {
 			return err
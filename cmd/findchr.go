@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Arguments struct {
+	Input string `arg:"positional,required"`
+
+	ConfigOutput  string `arg:"--config" help:"Write the regions found as an extractchr config to this file."`
+	HeatmapOutput string `arg:"--heatmap" help:"Write a PNG of the score of every 8 bytes of the input to this file."`
+	HeatmapWidth  int    `arg:"--heatmap-width" default:"128" help:"Width of the heatmap in pixels."`
+
+	Depths  string `arg:"--depths" default:"2,4" help:"Comma separated list of bit depths to look for.  Each plane of 2bpp data looks like a 1bpp tile, so 1bpp is left out by default."`
+	Formats string `arg:"--formats" default:"nes,snes" help:"Comma separated list of CHR formats to look for."`
+
+	Threshold   float64 `arg:"--threshold" default:"0.3" help:"Minimum score, from 0 to 1, for data to be considered graphics."`
+	WindowTiles int     `arg:"--window" default:"16" help:"Number of tiles scored together."`
+	MinTiles    int     `arg:"--min-tiles" default:"8" help:"Ignore regions shorter than this many tiles."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	if args.HeatmapWidth < 1 {
+		return fmt.Errorf("Invalid --heatmap-width: %d", args.HeatmapWidth)
+	}
+
+	opts := snesimg.ScanOptions{
+		WindowTiles: args.WindowTiles,
+		Threshold:   args.Threshold,
+		MinTiles:    args.MinTiles,
+	}
+
+	for _, d := range strings.Split(args.Depths, ",") {
+		var depth snesimg.BitDepth
+		err := depth.UnmarshalText([]byte(d))
+		if err != nil {
+			return err
+		}
+		opts.Depths = append(opts.Depths, depth)
+	}
+
+	for _, f := range strings.Split(args.Formats, ",") {
+		var format snesimg.ChrFormat
+		err := format.UnmarshalText([]byte(f))
+		if err != nil {
+			return err
+		}
+		opts.Formats = append(opts.Formats, format)
+	}
+
+	romfile, err := os.Open(args.Input)
+	if err != nil {
+		return err
+	}
+
+	romMap, err := rom.Detect(romfile)
+	romfile.Close()
+	if err != nil {
+		return err
+	}
+	labeler, _ := romMap.(rom.AddressLabeler)
+
+	data, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	result := snesimg.ScanChr(data, opts)
+
+	cfg := []snesimg.CfgSegment{}
+	for _, r := range result.Regions {
+		planes, _ := r.Depth.PlaneCount()
+		format := strings.ToLower(strings.TrimPrefix(r.Format.String(), "CF_"))
+
+		start := fmt.Sprintf("0x%X", r.Start)
+		if labeler != nil {
+			if addr, err := labeler.Address(r.Start); err == nil {
+				start = addr
+			}
+		}
+
+		fmt.Printf("%s 0x%06X %5d tiles %s %s score:%.2f\n",
+			start, r.Start, r.Tiles, r.Depth, r.Format, r.Score)
+
+		cfg = append(cfg, snesimg.CfgSegment{
+			Start:  start,
+			Depth:  planes,
+			Count:  strconv.Itoa(r.Tiles),
+			Format: format,
+		})
+	}
+
+	if args.ConfigOutput != "" {
+		raw, err := json.MarshalIndent(cfg, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(args.ConfigOutput, raw, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.HeatmapOutput != "" {
+		output, err := os.Create(args.HeatmapOutput)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+
+		err = png.Encode(output, snesimg.ScanHeatmap(result.Scores, args.HeatmapWidth))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
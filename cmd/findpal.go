@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/alexflint/go-arg"
+
+	"github.com/zorchenhimer/go-retroimg/palette"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Arguments struct {
+	Input string `arg:"positional,required" help:"ROM to search"`
+
+	Image  string `arg:"--image" help:"Look for every color used in this image, eg. a cropped screenshot."`
+	Colors string `arg:"--colors" help:"Comma separated list of colors to look for.  HTML colors like #00AA55, or NES color indexes like $0F for --system nes."`
+
+	System     string `arg:"--system" default:"nes" help:"nes, or the color space of the palette data: snes, gbc, genesis, pce, or sms."`
+	NesPalFile string `arg:"--nes-pal-file" help:".pal file used to match image colors to NES color indexes."`
+
+	MaxSpan int `arg:"--max-span" help:"Most palette entries a match can span.  Defaults to 32 for the NES and 16 otherwise."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	if (args.Image == "") == (args.Colors == "") {
+		return fmt.Errorf("Exactly one of --image or --colors is required")
+	}
+
+	nes := strings.ToLower(args.System) == "nes"
+
+	var cs palette.ColorSpace
+	if !nes {
+		var err error
+		cs, err = palette.ColorSpaceByName(args.System)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.MaxSpan == 0 {
+		args.MaxSpan = 16
+		if nes {
+			args.MaxSpan = 32
+		}
+	}
+
+	cm := palette.Nes_2C02
+	if args.NesPalFile != "" {
+		pal, err := palette.FromFile(args.NesPalFile, palette.PF_RawRGB)
+		if err != nil {
+			return err
+		}
+		cm = palette.NewColorMap(pal)
+	}
+
+	colors := color.Palette{}
+	nesColors := []uint8{}
+
+	if args.Image != "" {
+		file, err := os.Open(args.Image)
+		if err != nil {
+			return err
+		}
+
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		seen := map[color.RGBA]bool{}
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				if !seen[c] {
+					seen[c] = true
+					colors = append(colors, c)
+				}
+			}
+		}
+	}
+
+	for _, s := range strings.Split(args.Colors, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if nes && len(strings.TrimPrefix(s, "#")) != 6 {
+			idx, err := palette.ParseNesColor(s)
+			if err != nil {
+				return err
+			}
+			nesColors = append(nesColors, idx)
+			continue
+		}
+
+		c, err := palette.ParseHexColor(s)
+		if err != nil {
+			return err
+		}
+		colors = append(colors, c)
+	}
+
+	if len(colors) > args.MaxSpan {
+		return fmt.Errorf("%d colors can't fit in %d palette entries; crop the image or raise --max-span", len(colors), args.MaxSpan)
+	}
+
+	data, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	var matches []palette.PaletteMatch
+	size := 1
+	if nes {
+		for _, c := range colors {
+			nesColors = append(nesColors, palette.NesIndex(cm, c))
+		}
+
+		list := []string{}
+		for _, c := range nesColors {
+			list = append(list, fmt.Sprintf("$%02X", c))
+		}
+		fmt.Println("Looking for", strings.Join(list, " "))
+
+		matches = palette.FindNesPalette(data, nesColors, args.MaxSpan)
+	} else {
+		size = len(cs.Encode(color.Black))
+		matches = palette.FindPalette(data, cs, colors, args.MaxSpan)
+	}
+
+	if len(matches) == 0 {
+		fmt.Println("No matches found")
+		return nil
+	}
+
+	romfile, err := os.Open(args.Input)
+	if err != nil {
+		return err
+	}
+
+	romMap, err := rom.Detect(romfile)
+	romfile.Close()
+	if err != nil {
+		return err
+	}
+	labeler, _ := romMap.(rom.AddressLabeler)
+
+	for _, m := range matches {
+		start := fmt.Sprintf("0x%X", m.Offset)
+		if labeler != nil {
+			if addr, err := labeler.Address(m.Offset); err == nil {
+				start = addr
+			}
+		}
+
+		entries := []string{}
+		for i := 0; i < m.Length; i++ {
+			entry := data[m.Offset+int64(i*size) : m.Offset+int64((i+1)*size)]
+			entries = append(entries, fmt.Sprintf("%X", entry))
+		}
+
+		fmt.Printf("%s 0x%06X %3d entries: %s\n", start, m.Offset, m.Length, strings.Join(entries, " "))
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Arguments struct {
+	Input string `arg:"positional,required" help:"ROM to search"`
+	Image string `arg:"positional,required" help:"Screenshot or image of the graphics to look for"`
+
+	OffsetX int `arg:"--offset-x" help:"Horizontal offset in pixels of the tile grid in the image."`
+	OffsetY int `arg:"--offset-y" help:"Vertical offset in pixels of the tile grid in the image."`
+
+	ConfigOutput string `arg:"--config" help:"Write the matches as an extractchr config to this file."`
+
+	Depths  string `arg:"--depths" default:"1,2,4" help:"Comma separated list of bit depths to look for."`
+	Formats string `arg:"--formats" default:"nes,snes" help:"Comma separated list of CHR formats to look for."`
+
+	MinTiles int `arg:"--min-tiles" default:"4" help:"Ignore runs of fewer matching tiles than this."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	opts := snesimg.TileSearchOptions{
+		MinTiles: args.MinTiles,
+	}
+
+	for _, d := range strings.Split(args.Depths, ",") {
+		var depth snesimg.BitDepth
+		err := depth.UnmarshalText([]byte(d))
+		if err != nil {
+			return err
+		}
+		opts.Depths = append(opts.Depths, depth)
+	}
+
+	for _, f := range strings.Split(args.Formats, ",") {
+		var format snesimg.ChrFormat
+		err := format.UnmarshalText([]byte(f))
+		if err != nil {
+			return err
+		}
+		opts.Formats = append(opts.Formats, format)
+	}
+
+	imgfile, err := os.Open(args.Image)
+	if err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(imgfile)
+	imgfile.Close()
+	if err != nil {
+		return err
+	}
+
+	origin := img.Bounds().Min.Add(image.Pt(args.OffsetX, args.OffsetY))
+	tiles, width, err := snesimg.PatternTiles(img, origin)
+	if err != nil {
+		return err
+	}
+
+	romfile, err := os.Open(args.Input)
+	if err != nil {
+		return err
+	}
+
+	romMap, err := rom.Detect(romfile)
+	romfile.Close()
+	if err != nil {
+		return err
+	}
+	labeler, _ := romMap.(rom.AddressLabeler)
+
+	data, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	matches := snesimg.FindTiles(data, tiles, opts)
+	if len(matches) == 0 {
+		fmt.Println("No matches found")
+	}
+
+	cfg := []snesimg.CfgSegment{}
+	for _, m := range matches {
+		planes, _ := m.Depth.PlaneCount()
+		format := strings.ToLower(strings.TrimPrefix(m.Format.String(), "CF_"))
+
+		start := fmt.Sprintf("0x%X", m.Offset)
+		if labeler != nil {
+			if addr, err := labeler.Address(m.Offset); err == nil {
+				start = addr
+			}
+		}
+
+		seg := snesimg.CfgSegment{
+			Start:  start,
+			Depth:  planes,
+			Count:  strconv.Itoa(len(m.Tiles)),
+			Format: format,
+		}
+
+		hint := "no simple tile order"
+		w, h, order, ok := snesimg.TileOrderHint(m, width)
+		if ok {
+			orderStr := []string{}
+			for _, o := range order {
+				orderStr = append(orderStr, strconv.Itoa(o))
+			}
+
+			hint = fmt.Sprintf("%dx%d metatiles, order %s", w, h, strings.Join(orderStr, ","))
+			if w*h > 1 {
+				seg.Dimensions = fmt.Sprintf("%dx%d", w, h)
+				seg.TileOrder = strings.Join(orderStr, ",")
+				seg.Count = strconv.Itoa(len(m.Tiles) / (w * h))
+			}
+			if width/w > 0 {
+				seg.Stride = width / w
+			}
+		}
+
+		first := m.Tiles[0]
+		fmt.Printf("%s 0x%06X %5d tiles %s %s image tile %d,%d; %s\n",
+			start, m.Offset, len(m.Tiles), m.Depth, m.Format, first%width, first/width, hint)
+
+		cfg = append(cfg, seg)
+	}
+
+	if args.ConfigOutput != "" {
+		raw, err := json.MarshalIndent(cfg, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(args.ConfigOutput, raw, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	_ "image/png"
 	_ "image/jpeg"
@@ -13,6 +18,9 @@ import (
 	"github.com/alexflint/go-arg"
 
 	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/compress"
+	"github.com/zorchenhimer/go-retroimg/export"
+	"github.com/zorchenhimer/go-retroimg/palette"
 )
 
 type Arguments struct {
@@ -25,7 +33,124 @@ type Arguments struct {
 	// 2bpp in the ROM software.
 	BitDepth snesimg.BitDepth `arg:"--bit-depth,-d" default:"2" help:"Bits per pixel. Accepted values are 1, 2, 4, & 8 or 1bpp, 2bpp, 4bpp, & 8bpp."`
 
-	AsmOutput bool `arg:"--asm-out"`
+	AsmOutput string `arg:"--asm-out" help:"Write assembly instead of binary.  Accepted values are ca65, asm6, nesasm, wla-dx, bass, rgbds, 64tass, & c."`
+	Radix     string `arg:"--radix" default:"dec" help:"Write assembly values in hex or dec."`
+	PerLine   int    `arg:"--per-line" help:"Values per line of assembly.  Defaults to one tile per line."`
+	Label     string `arg:"--label" help:"Prefix for assembly labels.  Labels are only written when this is set, or for c."`
+	Comments  bool   `arg:"--comments" help:"Comment each tile in the assembly with its index."`
+
+	CHeader   string            `arg:"--c-header" help:"Write a header with extern declarations and size macros to this file.  Requires --asm-out c."`
+	Toolchain export.Toolchain `arg:"--toolchain" help:"Add section or bank pragmas to C output for this toolchain.  Accepted values are cc65, sdcc, & gbdk."`
+	Segment   string            `arg:"--segment" help:"Segment to place C arrays in with cc65 or sdcc."`
+	Bank      int               `arg:"--bank" default:"-1" help:"ROM bank to place C arrays in with gbdk."`
+
+	Format snesimg.ChrFormat `arg:"--format" default:"nes" help:"Order of the bit planes in the output. Accepted values are nes, snes, & gb."`
+
+	// --gb-pal green --gb-reg 0xE4
+	GbPal string `arg:"--gb-pal" help:"Map the input image's colors using DMG shades. Accepted values are green, pocket, & bgb."`
+	GbReg string `arg:"--gb-reg" default:"0xE4" help:"BGP, OBP0, or OBP1 register value used with --gb-pal."`
+	GbObj bool   `arg:"--gb-obj" help:"--gb-reg is OBP0 or OBP1, where color 0 is transparent.  Transparent pixels map to color 0 and opaque pixels never do."`
+
+	// --gbc-pal '#FFFFFF,#AAAAAA,#555555,#000000'
+	GbcPal       []string `arg:"--gbc-pal,separate" help:"GBC palette as four comma separated HTML colors, snapped to the colors a GBC can display.  Repeat for up to eight palettes.  The first palette is used to map the input image's colors."`
+	GbcPalOutput string   `arg:"--gbc-pal-out" help:"Write the 15-bit GBC palettes, as written through BCPD or OCPD, to this file.  Written as assembly if the extension is .inc, .asm, or .s, or as C if it is .c or .h."`
+
+	PaletteFile string `arg:"--pal-file" help:"Map the input image's colors using this GIMP palette."`
+	ColorSpace  string `arg:"--color-space" help:"Snap colors to what the target system can display before mapping them. Accepted values are snes, gbc, genesis, pce, & sms."`
+
+	// --nes-pal 0F,00,10,20 --nes-pal 0F,06,16,26
+	NesPal       []string `arg:"--nes-pal,separate" help:"NES palette as four color indexes.  Repeat for up to eight palettes, background palettes first.  The first palette is used to map the input image's colors."`
+	PalRamOutput string   `arg:"--pal-ram-out" help:"Write the 32 byte palette RAM image ($3F00-$3F1F) to this file.  Written as assembly if the extension is .inc, .asm, or .s, or as C if it is .c or .h."`
+
+	AlphaThreshold uint8 `arg:"--alpha-threshold" help:"Pixels with an alpha below this value (1-255) become color index 0.  Disabled by default."`
+
+	Compress string `arg:"--compress" help:"Compress the CHR data.  Accepted values are donut, lz4, lzss, gba-lz77, gba-rle, lc-lz2, lc-lz3, konami, neslib, & packbits."`
+
+	BankSize  string `arg:"--bank-size" help:"Split the output into banks of this many bytes, eg. 1k, 2k, 4k, or 8k.  Each bank is written to its own file with the bank number added to the name, eg. out_0.chr.  Binary banks are padded to the full size."`
+	BankIndex string `arg:"--bank-index" help:"Write the bank, offset, and size of each piece of the output to this file.  Written as assembly when --asm-out is given."`
+}
+
+// asmOptions returns the assembly output options, or nil if --asm-out
+// wasn't given.
+func asmOptions(args *Arguments) (*export.Options, error) {
+	opts, err := export.ParseOptions(args.AsmOutput, args.Radix)
+	if opts == nil || err != nil {
+		return nil, err
+	}
+
+	opts.PerLine = args.PerLine
+	opts.LabelPrefix = args.Label
+	opts.Comments = args.Comments
+	return opts, nil
+}
+
+// cOptions returns the C output options, or nil if the output isn't C.
+func cOptions(args *Arguments, asm *export.Options) (*export.COptions, error) {
+	if asm == nil || asm.Dialect != export.AD_C {
+		if args.CHeader != "" || args.Toolchain != export.TC_None {
+			return nil, fmt.Errorf("--c-header and --toolchain require --asm-out c")
+		}
+		return nil, nil
+	}
+
+	return &export.COptions{
+		Options:   *asm,
+		Toolchain: args.Toolchain,
+		Segment:   args.Segment,
+		Bank:      args.Bank,
+	}, nil
+}
+
+// nesPalettes returns the first of the palettes for mapping the image's
+// colors, and writes the palette RAM to palRamFile if it isn't empty.
+func nesPalettes(specs []string, palRamFile string, asm *export.Options) (color.Palette, error) {
+	ram, warnings, err := palette.ParsePaletteRam(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
+	}
+
+	if palRamFile != "" {
+		err = ram.WriteFile(palRamFile, asm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ram.Palettes(palette.Nes_2C02)[0], nil
+}
+
+// gbcPalettes returns the first of the palettes for mapping the image's
+// colors, and writes the encoded palettes to palFile if it isn't empty.
+func gbcPalettes(specs []string, palFile string, asm *export.Options) (color.Palette, error) {
+	pals := []color.Palette{}
+	for _, spec := range specs {
+		p, err := palette.ParseGbcPalette(spec)
+		if err != nil {
+			return nil, err
+		}
+		pals = append(pals, p)
+	}
+
+	data, err := palette.EncodeGbcPalettes(pals)
+	if err != nil {
+		return nil, err
+	}
+
+	if palFile != "" {
+		err = export.WriteFile(palFile, export.Table{
+			Name:   "gbc_palettes",
+			Blocks: export.Blocks(data, 8),
+		}, asm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pals[0], nil
 }
 
 func main() {
@@ -52,17 +177,125 @@ func run(args *Arguments) error {
 		return err
 	}
 
+	asm, err := asmOptions(args)
+	if err != nil {
+		return err
+	}
+
+	cOpts, err := cOptions(args, asm)
+	if err != nil {
+		return err
+	}
+
 	pal, err := args.BitDepth.DefaultPalette()
 	if err != nil {
 		return err
 	}
 
+	if len(args.NesPal) > 0 {
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --nes-pal with a 2bpp image")
+		}
+
+		pal, err = nesPalettes(args.NesPal, args.PalRamOutput, asm)
+		if err != nil {
+			return err
+		}
+	} else if args.PalRamOutput != "" {
+		return fmt.Errorf("--pal-ram-out requires --nes-pal")
+	}
+
+	if args.GbPal != "" {
+		if len(args.NesPal) > 0 {
+			return fmt.Errorf("Cannot use both --nes-pal and --gb-pal")
+		}
+
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --gb-pal with a 2bpp image")
+		}
+
+		tint, err := palette.DmgTint(args.GbPal)
+		if err != nil {
+			return err
+		}
+
+		reg, err := strconv.ParseUint(args.GbReg, 0, 8)
+		if err != nil {
+			return fmt.Errorf("Invalid --gb-reg value: %w", err)
+		}
+
+		if args.GbObj {
+			pal = palette.DmgObjPalette(tint, uint8(reg))
+		} else {
+			pal = palette.DmgPalette(tint, uint8(reg))
+		}
+	} else if args.GbObj {
+		return fmt.Errorf("--gb-obj requires --gb-pal")
+	}
+
+	if len(args.GbcPal) > 0 {
+		if len(args.NesPal) > 0 || args.GbPal != "" {
+			return fmt.Errorf("Cannot use --gbc-pal with --nes-pal or --gb-pal")
+		}
+
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --gbc-pal with a 2bpp image")
+		}
+
+		pal, err = gbcPalettes(args.GbcPal, args.GbcPalOutput, asm)
+		if err != nil {
+			return err
+		}
+	} else if args.GbcPalOutput != "" {
+		return fmt.Errorf("--gbc-pal-out requires --gbc-pal")
+	}
+
+	if args.PaletteFile != "" {
+		if len(args.NesPal) > 0 || args.GbPal != "" || len(args.GbcPal) > 0 {
+			return fmt.Errorf("Cannot use --pal-file with --nes-pal, --gb-pal, or --gbc-pal")
+		}
+
+		pal, err = palette.FromFile(args.PaletteFile, palette.PF_Gimp)
+		if err != nil {
+			return err
+		}
+
+		numColors, err := args.BitDepth.NumberColors()
+		if err != nil {
+			return err
+		}
+
+		if len(pal) > numColors {
+			pal = pal[:numColors]
+		}
+	}
+
+	opts := &snesimg.ConvertOptions{
+		AlphaThreshold: args.AlphaThreshold,
+	}
+	if args.ColorSpace != "" {
+		opts.ColorModel, err = palette.ColorSpaceByName(args.ColorSpace)
+		if err != nil {
+			return err
+		}
+	}
+
 	fmt.Println("BitDepth:", args.BitDepth)
 
-	ti, err := snesimg.NewTiledImageFromImage(snesimg.CS_8x8, args.BitDepth, pal, img)
+	ti, err := snesimg.NewTiledImageFromImageOptions(snesimg.CS_8x8, args.BitDepth, pal, img, opts)
 	if err != nil {
 		return err
 	}
+	ti.Format = args.Format
+
+	if args.BankSize != "" {
+		if args.CHeader != "" || args.Toolchain != export.TC_None {
+			return fmt.Errorf("Cannot use --bank-size with --c-header or --toolchain")
+		}
+		return writeBanks(args, ti, asm)
+	} else if args.BankIndex != "" {
+		return fmt.Errorf("--bank-index requires --bank-size")
+	}
 
 	output, err := os.Create(args.Output)
 	if err != nil {
@@ -70,11 +303,182 @@ func run(args *Arguments) error {
 	}
 	defer output.Close()
 
-	if args.AsmOutput {
-		err = ti.WriteAsm(output)
+	if cOpts != nil {
+		array := ti.CArray("chr")
+		if args.Compress != "" {
+			packed, err := compressChr(ti, args.Compress)
+			if err != nil {
+				return err
+			}
+
+			array.Defines = append(array.Defines, export.Define{Name: "UNPACKED_SIZE", Value: array.Size()})
+			array.Blocks = [][]byte{packed}
+			array.Comment = func(int) string { return args.Compress }
+
+			if cOpts.PerLine == 0 {
+				cOpts.PerLine = 16
+			}
+		}
+
+		return writeC(output, args.CHeader, *cOpts, []export.CArray{array})
+	}
+
+	if args.Compress != "" {
+		return writeCompressed(output, ti, args.Compress, asm)
+	}
+
+	if asm != nil {
+		err = ti.WriteAsmOptions(output, "chr", *asm)
 	} else {
 		err = ti.WriteBin(output)
 	}
 
 	return err
 }
+
+// bankFile adds the bank number to the end of the output's name.
+func bankFile(output string, bank int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(output, ext), bank, ext)
+}
+
+// writeBanks splits the CHR data into banks and writes each to its own file.
+func writeBanks(args *Arguments, ti *snesimg.TiledImage, asm *export.Options) error {
+	size, err := export.ParseSize(args.BankSize)
+	if err != nil {
+		return err
+	}
+
+	asset := ti.Asset("chr")
+	if args.Compress != "" {
+		packed, err := compressChr(ti, args.Compress)
+		if err != nil {
+			return err
+		}
+
+		// Compressed data is decompressed in one piece, so it can't be
+		// split across banks.
+		asset = export.Asset{Name: "chr", Data: packed}
+	}
+
+	banks, warnings, err := export.SplitBanks(size, []export.Asset{asset})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
+	}
+
+	for i := range banks.Data {
+		filename := bankFile(args.Output, i)
+		fmt.Printf("%s: %d of %d bytes\n", filename, len(banks.Data[i]), size)
+
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+
+		if asm != nil {
+			opts := *asm
+			if asset.Align == 0 && opts.PerLine == 0 {
+				opts.PerLine = 16
+			}
+			err = opts.WriteBank(file, fmt.Sprintf("chr_bank%d", i), banks, i, asset.Align)
+		} else {
+			_, err = file.Write(banks.Padded(i, 0))
+		}
+
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.BankIndex == "" {
+		return nil
+	}
+
+	file, err := os.Create(args.BankIndex)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	index := banks.IndexTable("chr_banks")
+	if asm != nil {
+		return asm.WriteTable(file, index)
+	}
+
+	_, err = file.Write(bytes.Join(index.Blocks, []byte{}))
+	return err
+}
+
+// writeC writes the arrays as C source to output, and their declarations to
+// a header if header isn't empty.
+func writeC(output *os.File, header string, opts export.COptions, arrays []export.CArray) error {
+	if header != "" {
+		file, err := os.Create(header)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		err = opts.WriteHeader(file, filepath.Base(header), arrays)
+		if err != nil {
+			return err
+		}
+
+		// Include it relative to the source.
+		rel, err := filepath.Rel(filepath.Dir(output.Name()), header)
+		if err != nil {
+			return err
+		}
+		header = filepath.ToSlash(rel)
+	}
+
+	return opts.WriteSource(output, header, arrays)
+}
+
+func compressChr(ti *snesimg.TiledImage, name string) ([]byte, error) {
+	codec, err := compress.ByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = ti.WriteBin(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := compress.RoundTrip(codec, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("%s: %d bytes compressed to %d\n", codec.Name(), buf.Len(), len(packed))
+	return packed, nil
+}
+
+func writeCompressed(output *os.File, ti *snesimg.TiledImage, name string, asm *export.Options) error {
+	packed, err := compressChr(ti, name)
+	if err != nil {
+		return err
+	}
+
+	if asm == nil {
+		_, err = output.Write(packed)
+		return err
+	}
+
+	opts := *asm
+	if opts.PerLine == 0 {
+		opts.PerLine = 16
+	}
+
+	return opts.WriteTable(output, export.Table{
+		Name:   "chr",
+		Blocks: [][]byte{packed},
+	})
+}
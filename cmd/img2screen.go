@@ -1,12 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
 
 	_ "image/png"
 	_ "image/jpeg"
@@ -15,6 +16,9 @@ import (
 	"github.com/alexflint/go-arg"
 
 	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/compress"
+	"github.com/zorchenhimer/go-retroimg/export"
+	"github.com/zorchenhimer/go-retroimg/palette"
 )
 
 type Arguments struct {
@@ -27,7 +31,27 @@ type Arguments struct {
 	// 2bpp in the ROM software.
 	BitDepth snesimg.BitDepth `arg:"--bit-depth,-d" default:"2" help:"Bits per pixel. Accepted values are 1, 2, 4, & 8 or 1bpp, 2bpp, 4bpp, & 8bpp."`
 
-	//AsmOutput bool `arg:"--asm-out"`
+	AsmOutput string `arg:"--asm-out" default:"ca65" help:"Assembler dialect for the nametable, splits, and an assembly --pal-ram-out.  Accepted values are ca65, asm6, nesasm, wla-dx, bass, rgbds, 64tass, & c.  The nametable is written to OutputBase.nt.inc, or OutputBase.nt.c for c."`
+	Radix     string `arg:"--radix" default:"dec" help:"Write assembly values in hex or dec."`
+
+	// --nes-pal 0F,00,10,20 --nes-pal 0F,06,16,26
+	NesPal       []string `arg:"--nes-pal,separate" help:"NES palette as four color indexes.  Repeat for up to eight palettes, background palettes first.  The first palette is used to map the input image's colors."`
+	PalRamOutput string   `arg:"--pal-ram-out" help:"Write the 32 byte palette RAM image ($3F00-$3F1F) to this file.  Written as assembly if the extension is .inc, .asm, or .s, or as C if it is .c or .h."`
+
+	AlphaThreshold uint8 `arg:"--alpha-threshold" help:"Pixels with an alpha below this value (1-255) become color index 0.  Disabled by default."`
+
+	Compress    string `arg:"--compress" help:"Compress the nametable.  Accepted values are neslib, konami, & packbits."`
+	ChrCompress string `arg:"--chr-compress" help:"Compress the CHR data.  Accepted values are donut, lz4, lzss, gba-lz77, gba-rle, lc-lz2, lc-lz3, konami, neslib, & packbits."`
+
+	BankSize string `arg:"--bank-size" help:"Place the CHR and nametables in banks of this many bytes instead of their own files, eg. 4k for MMC3 CHR banks or 16k for PRG banks.  Each bank is written to OutputBase_bankN.bin, padded to the full size, and the bank, offset, and size of each piece to OutputBase.banks.inc, or OutputBase.banks.c for c."`
+
+	Split bool `arg:"--split" help:"Split the screen into horizontal regions that each use at most 256 tiles of their own pattern table.  Each region's CHR is written to OutputBase_N.chr, and the scanlines to switch banks on to OutputBase.split.inc, or OutputBase.split.c for c."`
+
+	COutput   bool             `arg:"--c" help:"Also write the CHR, nametable, and palette RAM as C arrays to OutputBase.c and OutputBase.h."`
+	Label     string           `arg:"--label" help:"Prefix for the labels and C array names.  Defaults to the name of OutputBase."`
+	Toolchain export.Toolchain `arg:"--toolchain" help:"Add section or bank pragmas to C output for this toolchain.  Accepted values are cc65, sdcc, & gbdk."`
+	Segment   string           `arg:"--segment" help:"Segment to place C arrays in with cc65 or sdcc."`
+	Bank      int              `arg:"--bank" default:"-1" help:"ROM bank to place C arrays in with gbdk."`
 }
 
 func run(args *Arguments) error {
@@ -44,14 +68,37 @@ func run(args *Arguments) error {
 		return err
 	}
 
+	asm, err := asmOptions(args)
+	if err != nil {
+		return err
+	}
+
 	pal, err := args.BitDepth.DefaultPalette()
 	if err != nil {
 		return err
 	}
 
+	var ram *palette.PaletteRam
+	if len(args.NesPal) > 0 {
+		if args.BitDepth != snesimg.BD_2bpp {
+			return fmt.Errorf("Can only use --nes-pal with a 2bpp image")
+		}
+
+		pal, ram, err = nesPalettes(args.NesPal, args.PalRamOutput, asm)
+		if err != nil {
+			return err
+		}
+	} else if args.PalRamOutput != "" {
+		return fmt.Errorf("--pal-ram-out requires --nes-pal")
+	}
+
 	fmt.Println("BitDepth:", args.BitDepth)
 
-	ti, err := snesimg.NewTiledImageFromImage(snesimg.CS_8x8, args.BitDepth, pal, img)
+	opts := &snesimg.ConvertOptions{
+		AlphaThreshold: args.AlphaThreshold,
+	}
+
+	ti, err := snesimg.NewTiledImageFromImageOptions(snesimg.CS_8x8, args.BitDepth, pal, img, opts)
 	if err != nil {
 		return err
 	}
@@ -60,50 +107,402 @@ func run(args *Arguments) error {
 		return fmt.Errorf("Input image bounds too large: %#v", ti.Bounds().Max)
 	}
 
-	//ti.RemoveDuplicates()
-	unique := ti.UniqueTiles()
-	if len(unique) > 512 {
-		return fmt.Errorf("Too many unique tiles: %d", len(unique))
+	var arrays []export.CArray
+	var chr []chrFile
+	var chunks [][]byte
+
+	if args.Split {
+		arrays, chr, chunks, err = writeRegions(args, ti, asm)
+		if err != nil {
+			return err
+		}
+
+	} else {
+		//ti.RemoveDuplicates()
+		unique := ti.UniqueTiles()
+		if len(unique) > 512 {
+			return fmt.Errorf("Too many unique tiles: %d", len(unique))
+		}
+
+		if len(unique) > 256 {
+			fmt.Println("WARN: unique tiles > 256 @", len(unique))
+		}
+
+		file, array, err := chrData(args.OutputBase+".chr", "chr", unique, args.ChrCompress, 0)
+		if err != nil {
+			return err
+		}
+		chr = append(chr, file)
+		arrays = append(arrays, array)
+
+		// Tile IDs past 255 go in a second list.
+		chunks = [][]byte{{}}
+		for _, id := range ti.TileIds {
+			if id > 255 && len(chunks) == 1 {
+				chunks = append(chunks, []byte{})
+			}
+			chunks[len(chunks)-1] = append(chunks[len(chunks)-1], byte(id&0xFF))
+		}
+	}
+
+	if args.Compress != "" {
+		codec, err := compress.ByName(args.Compress)
+		if err != nil {
+			return err
+		}
+
+		for i, chunk := range chunks {
+			packed, err := compress.RoundTrip(codec, chunk)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%s: %d bytes compressed to %d\n", codec.Name(), len(chunk), len(packed))
+			chunks[i] = packed
+		}
+	}
+
+	width := ti.Bounds().Dx() / 8
+	height := ti.Bounds().Dy() / 8
+	ntArrays := []export.CArray{}
+	for i, chunk := range chunks {
+		name := "nametable"
+		if i > 0 {
+			name = fmt.Sprintf("nametable_%d", i)
+		}
+		ntArrays = append(ntArrays, snesimg.NametableCArray(name, chunk, width, height, args.Compress != ""))
+	}
+
+	if args.BankSize != "" {
+		err = writeBanks(args, chr, ntArrays, asm)
+		if err != nil {
+			return err
+		}
+
+	} else {
+		for _, file := range chr {
+			err = os.WriteFile(file.Filename, file.Asset.Data, 0644)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = writeNametable(args.OutputBase+".nt"+asm.Dialect.Ext(), len(ti.Tiles), ntArrays, asm)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !args.Split {
+		fmt.Println("len(ti.TileIds):", len(ti.TileIds))
+	}
+
+	if !args.COutput {
+		return nil
+	}
+
+	arrays = append(arrays, ntArrays...)
+
+	if ram != nil {
+		arrays = append(arrays, ram.CArray("palettes"))
+	}
+
+	return writeC(args, arrays)
+}
+
+// asmOptions returns the options for the assembly output, with labels
+// defaulting to the name of OutputBase.
+func asmOptions(args *Arguments) (*export.Options, error) {
+	opts, err := export.ParseOptions(args.AsmOutput, args.Radix)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		return nil, fmt.Errorf("--asm-out can't be empty")
 	}
 
-	if len(unique) > 256 {
-		fmt.Println("WARN: unique tiles > 256 @", len(unique))
+	opts.LabelPrefix = labelPrefix(args)
+	return opts, nil
+}
+
+// labelPrefix is --label, or the name of OutputBase if it wasn't given.
+func labelPrefix(args *Arguments) string {
+	if args.Label != "" {
+		return args.Label
 	}
+	return export.Identifier(filepath.Base(args.OutputBase)) + "_"
+}
 
-	chrFile, err := os.Create(args.OutputBase+".chr")
+// writeNametable writes the number of tiles in the screen followed by the
+// nametable arrays to filename.
+func writeNametable(filename string, size int, arrays []export.CArray, asm *export.Options) error {
+	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	err = unique.WriteChr(chrFile)
+	err = asm.WriteWord(file, "nametable_size", uint16(size))
 	if err != nil {
 		return err
 	}
 
-	ntFile, err := os.Create(args.OutputBase+".nt.inc")
+	for _, array := range arrays {
+		err = asm.WriteTable(file, array.Table)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chrFile is CHR data and the file it's written to when it isn't placed in
+// banks.
+type chrFile struct {
+	Filename string
+	Asset    export.Asset
+}
+
+// chrData returns tiles as an asset called name, compressed with codec if it
+// isn't empty, along with a C array of them.  Uncompressed tiles are padded
+// out to padTo bytes.
+func chrData(filename, name string, tiles snesimg.TileList, codec string, padTo int) (chrFile, export.CArray, error) {
+	array := tiles.CArray(name, snesimg.CF_Nes)
+	file := chrFile{
+		Filename: filename,
+		Asset:    tiles.Asset(name, snesimg.CF_Nes),
+	}
+
+	if codec != "" {
+		c, err := compress.ByName(codec)
+		if err != nil {
+			return file, array, err
+		}
+
+		packed, err := compress.RoundTrip(c, file.Asset.Data)
+		if err != nil {
+			return file, array, err
+		}
+
+		fmt.Printf("%s: %d bytes of CHR compressed to %d\n", c.Name(), len(file.Asset.Data), len(packed))
+		array.Defines = append(array.Defines, export.Define{Name: "UNPACKED_SIZE", Value: len(file.Asset.Data)})
+		array.Blocks = export.Blocks(packed, 16)
+		array.Comment = nil
+
+		// Packed CHR has no tile boundaries to split at and has to fit
+		// in a single bank.
+		file.Asset = export.Asset{Name: name, Data: packed}
+		return file, array, nil
+	}
+
+	if len(file.Asset.Data) < padTo {
+		file.Asset.Data = append(file.Asset.Data, make([]byte, padTo-len(file.Asset.Data))...)
+	}
+
+	return file, array, nil
+}
+
+// writeBanks places the CHR and nametables in banks of --bank-size bytes,
+// writes each bank to its own file, and writes the index of the banks.
+func writeBanks(args *Arguments, chr []chrFile, nametables []export.CArray, asm *export.Options) error {
+	size, err := export.ParseSize(args.BankSize)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintln(ntFile, ": .word", len(ti.TileIds))
-	fmt.Fprint(ntFile, ": .byte ")
+	assets := []export.Asset{}
+	for _, file := range chr {
+		assets = append(assets, file.Asset)
+	}
+
+	for _, array := range nametables {
+		assets = append(assets, export.Asset{
+			Name: array.Name,
+			Data: bytes.Join(array.Blocks, []byte{}),
+		})
+	}
 
-	ids := []string{}
-	split := false
-	for _, id := range ti.TileIds {
-		if id > 255 && !split {
-			fmt.Fprintln(ntFile, strings.Join(ids, ", "))
-			fmt.Fprint(ntFile, ": .byte ")
-			ids = []string{}
-			split = true
+	banks, warnings, err := export.SplitBanks(size, assets)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
+	}
+
+	for i := range banks.Data {
+		filename := fmt.Sprintf("%s_bank%d.bin", args.OutputBase, i)
+		fmt.Printf("%s: %d of %d bytes\n", filename, len(banks.Data[i]), size)
+
+		err = os.WriteFile(filename, banks.Padded(i, 0), 0644)
+		if err != nil {
+			return err
 		}
-		ids = append(ids, strconv.Itoa(id&0xFF))
 	}
-	fmt.Fprintln(ntFile, strings.Join(ids, ", "))
 
-	fmt.Println("len(ti.TileIds):", len(ti.TileIds))
+	file, err := os.Create(args.OutputBase + ".banks" + asm.Dialect.Ext())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	return nil
+	return asm.WriteTable(file, banks.IndexTable("banks"))
+}
+
+// writeRegions splits the screen into regions of at most 256 tiles and
+// writes the scanlines the regions start on.  The CHR for each region is
+// returned along with the nametable as a single chunk of IDs into each
+// region's tiles.
+func writeRegions(args *Arguments, ti *snesimg.TiledImage, asm *export.Options) ([]export.CArray, []chrFile, [][]byte, error) {
+	regions, err := ti.SplitRows(256)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	arrays := []export.CArray{}
+	chr := []chrFile{}
+	nametable := []byte{}
+	scanlines := []byte{}
+
+	for i, r := range regions {
+		filename := fmt.Sprintf("%s_%d.chr", args.OutputBase, i)
+		fmt.Printf("%s: rows %d-%d, scanline %d, %d tiles\n", filename, r.Row, r.Row+r.Rows-1, r.Scanline, len(r.Tiles))
+
+		// Padded to a full pattern table.
+		file, array, err := chrData(filename, fmt.Sprintf("chr_%d", i), r.Tiles, args.ChrCompress, 256*16)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		chr = append(chr, file)
+		arrays = append(arrays, array)
+
+		for _, id := range r.TileIds {
+			nametable = append(nametable, byte(id))
+		}
+
+		if i > 0 {
+			scanlines = append(scanlines, byte(r.Scanline))
+		}
+	}
+
+	if len(scanlines) == 0 {
+		fmt.Println("The screen fits in a single pattern table; no splits needed")
+		return arrays, chr, [][]byte{nametable}, nil
+	}
+
+	splitFile, err := os.Create(args.OutputBase+".split"+asm.Dialect.Ext())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer splitFile.Close()
+
+	// The counter is reloaded from the latch on the first scanline, and
+	// again on the scanline after each IRQ, and fires at the end of the
+	// scanline it counts down to.  The first latch is the line above the
+	// first split, and each one after that is counted from the split before
+	// it.
+	latches := []byte{}
+	prev := byte(0)
+	for _, sl := range scanlines {
+		latches = append(latches, sl-prev-1)
+		prev = sl
+	}
+
+	splitOpts := *asm
+	splitOpts.Comments = true
+
+	err = splitOpts.WriteTable(splitFile, export.Table{
+		Name:   "split_scanlines",
+		Blocks: [][]byte{scanlines},
+		Comment: func(int) string {
+			return "Scanline each region after the first starts on"
+		},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = splitOpts.WriteTable(splitFile, export.Table{
+		Name:   "split_latches",
+		Blocks: [][]byte{latches},
+		Comment: func(int) string {
+			return "MMC3 IRQ latch values for the splits, each counted from the split before it"
+		},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	arrays = append(arrays, export.CArray{
+		Table: export.Table{
+			Name:   "splits",
+			Blocks: [][]byte{scanlines},
+		},
+	})
+
+	return arrays, chr, [][]byte{nametable}, nil
+}
+
+// writeC writes the arrays to OutputBase.c and their declarations to
+// OutputBase.h.
+func writeC(args *Arguments, arrays []export.CArray) error {
+	base := filepath.Base(args.OutputBase)
+
+	opts := export.COptions{
+		Options: export.Options{
+			Dialect:     export.AD_C,
+			Hex:         true,
+			LabelPrefix: labelPrefix(args),
+		},
+		Toolchain: args.Toolchain,
+		Segment:   args.Segment,
+		Bank:      args.Bank,
+	}
+
+	header, err := os.Create(args.OutputBase+".h")
+	if err != nil {
+		return err
+	}
+	defer header.Close()
+
+	err = opts.WriteHeader(header, base+".h", arrays)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Create(args.OutputBase+".c")
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return opts.WriteSource(source, base+".h", arrays)
+}
+
+// nesPalettes returns the first of the palettes for mapping the image's
+// colors along with the palette RAM, and writes the palette RAM to
+// palRamFile if it isn't empty.
+func nesPalettes(specs []string, palRamFile string, asm *export.Options) (color.Palette, *palette.PaletteRam, error) {
+	ram, warnings, err := palette.ParsePaletteRam(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
+	}
+
+	if palRamFile != "" {
+		err = ram.WriteFile(palRamFile, asm)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return ram.Palettes(palette.Nes_2C02)[0], ram, nil
 }
 
 func main() {
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/export"
+)
+
+type Arguments struct {
+	Input      string `arg:"positional,required" help:"Sprite sheet"`
+	OutputBase string `arg:"positional,required" help:"CHR is written to OutputBase.chr and the metasprites to OutputBase.inc, or OutputBase.c for c."`
+
+	System     snesimg.SpriteLayout `arg:"--system" default:"nes" help:"Accepted values are nes & snes."`
+	SpriteSize string               `arg:"--sprite-size" help:"Hardware sprite size.  8x8 or 8x16 for nes, defaulting to 8x8.  An OBJ size pair for snes: 8/16, 8/32, 8/64, 16/32, 16/64, 32/64, 16x32/32x64, or 16x32/32x32, defaulting to 8/16."`
+
+	Frame  string `arg:"--frame" help:"Size of each frame in pixels, eg. 32x32.  Frames are read left to right, top to bottom.  Defaults to the whole image."`
+	Origin string `arg:"--origin" default:"0,0" help:"Point in each frame that sprite offsets are relative to."`
+
+	Palette  int `arg:"--palette" help:"Palette number written to the sprite attributes."`
+	Priority int `arg:"--priority" default:"2" help:"Priority written to the sprite attributes on snes."`
+
+	Format    string `arg:"--format" default:"neslib" help:"Metasprite format.  neslib is x, y, tile, & attributes for each sprite, ending with 128.  generic starts with the sprite count and has no end marker.  Accepted values are neslib & generic.  Sprites have an extra byte that is 1 for the large size with --system snes."`
+	AsmOutput string `arg:"--asm-out" default:"ca65" help:"Assembler dialect for the metasprites.  Accepted values are ca65, asm6, nesasm, wla-dx, bass, rgbds, 64tass, & c."`
+	Label     string `arg:"--label" help:"Prefix for the metasprite labels.  Defaults to the name of OutputBase."`
+
+	AlphaThreshold uint8 `arg:"--alpha-threshold" help:"Pixels with an alpha below this value (1-255) become transparent.  Disabled by default."`
+}
+
+var snesSizes = []string{"8/16", "8/32", "8/64", "16/32", "16/64", "32/64", "16x32/32x64", "16x32/32x32"}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	sizes, depth, format, err := systemOptions(args)
+	if err != nil {
+		return err
+	}
+
+	opts, err := export.ParseOptions(args.AsmOutput, "hex")
+	if err != nil {
+		return err
+	}
+
+	opts.LabelPrefix = args.Label
+	if opts.LabelPrefix == "" {
+		opts.LabelPrefix = export.Identifier(filepath.Base(args.OutputBase)) + "_"
+	}
+
+	switch args.Format {
+	case "neslib", "generic":
+	default:
+		return fmt.Errorf("Invalid --format: %q", args.Format)
+	}
+
+	origin, err := parsePoint(args.Origin, ",")
+	if err != nil {
+		return fmt.Errorf("Invalid --origin: %w", err)
+	}
+
+	input, err := os.Open(args.Input)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	img, _, err := image.Decode(input)
+	if err != nil {
+		return err
+	}
+
+	pal, err := depth.DefaultPalette()
+	if err != nil {
+		return err
+	}
+
+	ti, err := snesimg.NewTiledImageFromImageOptions(snesimg.CS_8x8, depth, pal, img, &snesimg.ConvertOptions{
+		AlphaThreshold: args.AlphaThreshold,
+	})
+	if err != nil {
+		return err
+	}
+
+	frameSize := ti.Bounds().Size()
+	if args.Frame != "" {
+		frameSize, err = parsePoint(args.Frame, "x")
+		if err != nil {
+			return fmt.Errorf("Invalid --frame: %w", err)
+		}
+	}
+
+	if frameSize.X <= 0 || frameSize.Y <= 0 {
+		return fmt.Errorf("Invalid frame size: %dx%d", frameSize.X, frameSize.Y)
+	}
+
+	sheet := snesimg.NewSpriteSheet(args.System, sizes, depth, pal)
+	tables := []export.Table{}
+
+	for y := 0; y+frameSize.Y <= ti.Bounds().Max.Y; y += frameSize.Y {
+		for x := 0; x+frameSize.X <= ti.Bounds().Max.X; x += frameSize.X {
+			r := image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x, y).Add(frameSize)}
+
+			ms, err := sheet.MetaSprite(ti, r, r.Min.Add(origin))
+			if err != nil {
+				return err
+			}
+
+			table, err := metaSpriteTable(args, ms, len(tables))
+			if err != nil {
+				return fmt.Errorf("frame %d: %w", len(tables), err)
+			}
+
+			fmt.Printf("frame %d: %d sprites\n", len(tables), len(ms.Sprites))
+			tables = append(tables, table)
+		}
+	}
+
+	tiles := sheet.TileList()
+	fmt.Println("tiles:", len(tiles))
+
+	chrFile, err := os.Create(args.OutputBase + ".chr")
+	if err != nil {
+		return err
+	}
+	defer chrFile.Close()
+
+	err = tiles.WriteChrFormat(chrFile, format)
+	if err != nil {
+		return err
+	}
+
+	asmFile, err := os.Create(args.OutputBase + opts.Dialect.Ext())
+	if err != nil {
+		return err
+	}
+	defer asmFile.Close()
+
+	for _, table := range tables {
+		err = opts.WriteTable(asmFile, table)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// systemOptions checks the sprite size against the system and returns it
+// along with the system's bit depth and CHR format.
+func systemOptions(args *Arguments) (snesimg.SpriteSizes, snesimg.BitDepth, snesimg.ChrFormat, error) {
+	if args.System == snesimg.SL_Nes {
+		size := args.SpriteSize
+		if size == "" {
+			size = "8x8"
+		}
+
+		if size != "8x8" && size != "8x16" {
+			return snesimg.SpriteSizes{}, 0, 0, fmt.Errorf("NES sprites are 8x8 or 8x16, not %q", size)
+		}
+
+		sizes, err := snesimg.ParseSpriteSizes(size)
+		return sizes, snesimg.BD_2bpp, snesimg.CF_Nes, err
+	}
+
+	size := args.SpriteSize
+	if size == "" {
+		size = "8/16"
+	}
+
+	found := false
+	for _, s := range snesSizes {
+		if s == size {
+			found = true
+		}
+	}
+
+	if !found {
+		return snesimg.SpriteSizes{}, 0, 0, fmt.Errorf("Invalid SNES OBJ sizes %q; accepted values are %s", size, strings.Join(snesSizes, ", "))
+	}
+
+	sizes, err := snesimg.ParseSpriteSizes(size)
+	return sizes, snesimg.BD_4bpp, snesimg.CF_Snes, err
+}
+
+// metaSpriteTable returns the metasprite in the format given by --format,
+// one block per sprite.
+func metaSpriteTable(args *Arguments, ms *snesimg.MetaSprite, frame int) (export.Table, error) {
+	blocks := [][]byte{}
+	if args.Format == "generic" {
+		blocks = append(blocks, []byte{byte(len(ms.Sprites))})
+	}
+
+	for _, s := range ms.Sprites {
+		var entry []byte
+		var err error
+		if args.System == snesimg.SL_Nes {
+			entry, err = s.NesEntry(args.Palette)
+		} else {
+			entry, err = s.SnesEntry(args.Palette, args.Priority)
+		}
+
+		if err != nil {
+			return export.Table{}, err
+		}
+
+		// An x of -128 is written as 128, which ends the metasprite.
+		if args.Format == "neslib" && s.X == -128 {
+			return export.Table{}, fmt.Errorf("Sprite offset %d,%d is out of range; neslib x offsets are -127 to 127", s.X, s.Y)
+		}
+		blocks = append(blocks, entry)
+	}
+
+	if args.Format == "neslib" {
+		blocks = append(blocks, []byte{128})
+	}
+
+	return export.Table{
+		Name:   fmt.Sprintf("frame_%d", frame),
+		Blocks: blocks,
+	}, nil
+}
+
+// parsePoint parses two numbers separated by sep, eg. "8,16" or "32x32".
+func parsePoint(s, sep string) (image.Point, error) {
+	a, b, found := strings.Cut(s, sep)
+	if !found {
+		return image.Point{}, fmt.Errorf("expected two numbers separated by %q: %q", sep, s)
+	}
+
+	x, err := strconv.Atoi(strings.TrimSpace(a))
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	y, err := strconv.Atoi(strings.TrimSpace(b))
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	return image.Pt(x, y), nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/compress"
+	"github.com/zorchenhimer/go-retroimg/patch"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Arguments struct {
+	Input  string `arg:"positional,required" help:"ROM to inject the graphics into"`
+	Config string `arg:"positional,required" help:"Segment config used with extractchr"`
+
+	InDir  string `arg:"--input" help:"Directory with the edited images.  Defaults to the same directory extractchr writes to."`
+	Output string `arg:"--output" help:"Write the modified ROM to this file instead of overwriting the input."`
+
+	// The input ROM is left alone when writing a patch, unless --output is
+	// also given.
+	IpsOutput string `arg:"--ips" help:"Write an IPS patch against the input ROM."`
+	BpsOutput string `arg:"--bps" help:"Write a BPS patch against the input ROM."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	romfile, err := os.Open(args.Input)
+	if err != nil {
+		return err
+	}
+
+	romMap, err := rom.Detect(romfile)
+	romfile.Close()
+	if err != nil {
+		return err
+	}
+
+	segments, warnings, err := snesimg.ParseSegmentConfig(args.Config, romMap)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN:", w)
+	}
+
+	original, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, len(original))
+	copy(data, original)
+
+	if args.InDir == "" {
+		args.InDir = snesimg.SegmentDir(args.Input)
+	}
+
+	writePatch := args.IpsOutput != "" || args.BpsOutput != ""
+	if args.Output == "" && !writePatch {
+		args.Output = args.Input
+	}
+
+	for num, seg := range segments {
+		inname := seg.Filename(num)
+		fmt.Println(inname, seg)
+
+		// Segments cut short by the end of the data were extracted with fewer
+		// metatiles, and narrower images.  Ones without a whole metatile
+		// weren't extracted at all.
+		count, err := seg.MetaTiles(bytes.NewReader(original))
+		if err != nil {
+			return fmt.Errorf("%s: %w", inname, err)
+		}
+
+		if count == 0 {
+			fmt.Printf("WARN: skipping %s; no whole metatiles at 0x%X\n", inname, seg.Start)
+			continue
+		}
+
+		chr, err := encodeSegment(filepath.Join(args.InDir, inname), seg, count, original)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inname, err)
+		}
+
+		if seg.Compression != nil {
+			chr, err = recompress(seg, chr, original)
+			if err != nil {
+				return fmt.Errorf("%s: %w", inname, err)
+			}
+		}
+
+		if seg.Start+len(chr) > len(data) {
+			return fmt.Errorf("%s: segment ends at 0x%X, past the end of the ROM", inname, seg.Start+len(chr))
+		}
+
+		copy(data[seg.Start:], chr)
+	}
+
+	if args.IpsOutput != "" {
+		ips, err := patch.CreateIPS(original, data)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(args.IpsOutput, ips, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.BpsOutput != "" {
+		err = os.WriteFile(args.BpsOutput, patch.CreateBPS(original, data), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Output == "" {
+		return nil
+	}
+
+	return os.WriteFile(args.Output, data, 0644)
+}
+
+// recompress puts the tiles in chr back into the segment's decompressed data
+// and compresses it again.  It has to fit in the space the original data
+// used.
+func recompress(seg snesimg.Segment, chr, romData []byte) ([]byte, error) {
+	r, consumed, err := seg.Open(bytes.NewReader(romData))
+	if err != nil {
+		return nil, err
+	}
+
+	unpacked, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chr) > len(unpacked) {
+		return nil, fmt.Errorf("segment grew from %d to %d bytes", len(unpacked), len(chr))
+	}
+	copy(unpacked, chr)
+
+	packed, err := compress.RoundTrip(seg.Compression, unpacked)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packed) > consumed {
+		return nil, fmt.Errorf("compressed to %d bytes, which doesn't fit in the original %d", len(packed), consumed)
+	}
+
+	fmt.Printf("%s: %d of %d bytes used\n", seg.Compression.Name(), len(packed), consumed)
+	return packed, nil
+}
+
+func encodeSegment(filename string, seg snesimg.Segment, count int, romData []byte) ([]byte, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	img, _, err := image.Decode(input)
+	if err != nil {
+		return nil, err
+	}
+
+	pal, err := seg.Depth.DefaultPalette()
+	if err != nil {
+		return nil, err
+	}
+
+	if seg.Palette >= 0 {
+		pal, err = seg.ReadPalette(bytes.NewReader(romData), len(pal))
+		if err != nil {
+			return nil, fmt.Errorf("palette at 0x%X: %w", seg.Palette, err)
+		}
+
+		// Pixels are matched back to indexes by color.
+		for i, c := range pal {
+			if pal.Index(c) != i {
+				return nil, fmt.Errorf("palette at 0x%X repeats a color; remove Palette from the segment to inject it", seg.Palette)
+			}
+		}
+	}
+
+	tiles, err := snesimg.TilesFromMetaImage(
+		img,
+		seg.Depth,
+		pal,
+		seg.Width,
+		seg.Height,
+		seg.TileOrder,
+		seg.Stride,
+		count,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tiles) > seg.TileCount() {
+		return nil, fmt.Errorf("segment grew from %d to %d tiles", seg.TileCount(), len(tiles))
+	}
+
+	chr := []byte{}
+	for _, t := range tiles {
+		chr = append(chr, seg.Format.Encode(t)...)
+	}
+
+	return chr, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+
+	"github.com/zorchenhimer/go-retroimg/palette"
+)
+
+type Arguments struct {
+	Output string `arg:"positional,required" help:"Output .pal file"`
+
+	Hue        float64 `arg:"--hue" default:"0.0" help:"Hue shift in degrees."`
+	Saturation float64 `arg:"--saturation" default:"1.0"`
+	Contrast   float64 `arg:"--contrast" default:"1.0"`
+	Brightness float64 `arg:"--brightness" default:"0.0"`
+	Gamma      float64 `arg:"--gamma" default:"2.2" help:"Gamma of the emulated TV.  2.2 applies no correction."`
+
+	Revision palette.PpuRevision `arg:"--ppu" default:"2c02" help:"PPU revision. Accepted values are 2C02 (NTSC) and 2C07 (PAL)."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	cm := palette.GenerateNes(palette.NtscParams{
+		Hue:        args.Hue,
+		Saturation: args.Saturation,
+		Contrast:   args.Contrast,
+		Brightness: args.Brightness,
+		Gamma:      args.Gamma,
+		Revision:   args.Revision,
+	})
+
+	output, err := os.Create(args.Output)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	return cm.WritePal(output)
+}
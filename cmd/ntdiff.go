@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/export"
+)
+
+type Arguments struct {
+	Old    string `arg:"positional,required" help:"Nametable or CHR currently in VRAM."`
+	New    string `arg:"positional,required" help:"Nametable or CHR to update it to."`
+	Output string `arg:"positional,required" help:"File to write the stripe buffer to."`
+
+	Address    string `arg:"--address" default:"0x2000" help:"PPU address the data starts at."`
+	NoVertical bool   `arg:"--no-vertical" help:"Only use horizontal stripes.  Vertical stripes are only used for data starting at a nametable."`
+
+	FrameCycles int `arg:"--frame-cycles" help:"Split the updates into frames of at most this many estimated CPU cycles, eg. 1760 for an NTSC vblank after OAM DMA.  Each frame is written to its own file with the frame number added to the name."`
+
+	AsmOutput string `arg:"--asm-out" help:"Write assembly instead of binary, one stripe per line.  Accepted values are ca65, asm6, nesasm, wla-dx, bass, rgbds, 64tass, & c."`
+	Label     string `arg:"--label" help:"Prefix for assembly labels.  Labels are only written when this is set, or for c."`
+	Comments  bool   `arg:"--comments" help:"Comment each stripe in the assembly with its address and length."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	address, err := strconv.ParseUint(strings.Replace(args.Address, "$", "0x", 1), 0, 16)
+	if err != nil {
+		return fmt.Errorf("Invalid --address: %w", err)
+	}
+
+	asm, err := export.ParseOptions(args.AsmOutput, "hex")
+	if err != nil {
+		return err
+	}
+
+	if asm != nil {
+		asm.LabelPrefix = args.Label
+		asm.Comments = args.Comments
+	}
+
+	oldData, err := readScreen(args.Old)
+	if err != nil {
+		return err
+	}
+
+	newData, err := readScreen(args.New)
+	if err != nil {
+		return err
+	}
+
+	vertical := !args.NoVertical && address >= 0x2000 && address < 0x3000 && address&0x3FF == 0
+
+	stripes, err := snesimg.DiffStripes(oldData, newData, uint16(address), vertical)
+	if err != nil {
+		return err
+	}
+
+	frames := [][]snesimg.Stripe{stripes}
+	if args.FrameCycles > 0 {
+		frames = snesimg.SplitStripeFrames(stripes, args.FrameCycles)
+	}
+
+	for i, frame := range frames {
+		filename := args.Output
+		if len(frames) > 1 {
+			ext := filepath.Ext(filename)
+			filename = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(filename, ext), i, ext)
+		}
+
+		buf := snesimg.StripeBuffer(frame)
+		cycles := snesimg.StripeCycles(frame)
+		fmt.Printf("%s: %d stripes, %d bytes, ~%d cycles\n", filename, len(frame), len(buf), cycles)
+		if cycles > snesimg.NtscVblankCycles {
+			fmt.Printf("WARN: %s takes longer than an NTSC vblank (~%d cycles)\n", filename, snesimg.NtscVblankCycles)
+		}
+
+		err = writeStripes(filename, frame, asm)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeStripes(filename string, stripes []snesimg.Stripe, asm *export.Options) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if asm == nil {
+		_, err = file.Write(snesimg.StripeBuffer(stripes))
+		return err
+	}
+
+	blocks := [][]byte{}
+	for _, s := range stripes {
+		blocks = append(blocks, s.Bytes())
+	}
+	blocks = append(blocks, []byte{snesimg.StripeEnd})
+
+	return asm.WriteTable(file, export.Table{
+		Name:   "stripes",
+		Blocks: blocks,
+		Comment: func(i int) string {
+			if i == len(stripes) {
+				return "end"
+			}
+			return stripes[i].String()
+		},
+	})
+}
+
+// readScreen reads a binary file, or the .byte or .db values of an assembly
+// file such as the uncompressed .nt.inc from img2screen.
+func readScreen(filename string) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".inc", ".asm", ".s":
+	default:
+		return os.ReadFile(filename)
+	}
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte{}
+	for num, line := range strings.Split(string(raw), "\n") {
+		line, _, _ = strings.Cut(line, ";")
+		fields := strings.Fields(line)
+
+		var values string
+		for i, f := range fields {
+			switch strings.ToLower(f) {
+			case ".byte", ".db", "db":
+				values = strings.Join(fields[i+1:], "")
+			}
+		}
+
+		if values == "" {
+			continue
+		}
+
+		for _, v := range strings.Split(values, ",") {
+			v = strings.Replace(v, "$", "0x", 1)
+			b, err := strconv.ParseUint(v, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid byte %q", filename, num+1, v)
+			}
+			data = append(data, byte(b))
+		}
+	}
+
+	return data, nil
+}
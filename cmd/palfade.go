@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+
+	"github.com/zorchenhimer/go-retroimg/export"
+	"github.com/zorchenhimer/go-retroimg/palette"
+)
+
+type Arguments struct {
+	Output string `arg:"positional,required"`
+
+	System string `arg:"--system" default:"nes" help:"Target system. Accepted values are nes, snes, gbc, genesis, pce, & sms."`
+
+	// --colors 0F,00,10,20 for the NES, --colors #000000,#FFFFFF otherwise
+	Colors      string `arg:"--colors" help:"Comma separated palette.  NES color indexes for nes, HTML colors (eg #00AA55) for everything else."`
+	PaletteFile string `arg:"--pal-file" help:"Read palette colors from a GIMP palette file.  Not valid for nes."`
+
+	Target string `arg:"--to" default:"black" help:"Fade toward black, white, or a palette in the same format as --colors."`
+	Steps  int    `arg:"--steps" default:"4" help:"Number of palettes to generate.  The last one is the target."`
+
+	AsmOutput string `arg:"--asm-out" help:"Write assembly instead of binary.  Accepted values are ca65, asm6, nesasm, wla-dx, bass, rgbds, 64tass, & c."`
+	Radix     string `arg:"--radix" default:"hex" help:"Write assembly values in hex or dec."`
+	PerLine   int    `arg:"--per-line" help:"Values per line of assembly.  Defaults to one palette per line."`
+	Label     string `arg:"--label" help:"Prefix for assembly labels.  Labels are only written when this is set, or for c."`
+	Comments  bool   `arg:"--comments" help:"Comment each palette in the assembly with its step."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	asm, err := asmOptions(args)
+	if err != nil {
+		return err
+	}
+
+	var steps [][]byte
+
+	if strings.ToLower(args.System) == "nes" {
+		steps, err = fadeNes(args)
+	} else {
+		var cs palette.ColorSpace
+		cs, err = palette.ColorSpaceByName(args.System)
+		if err != nil {
+			return err
+		}
+		steps, err = fadeRGB(args, cs)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	output, err := os.Create(args.Output)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	if asm != nil {
+		return asm.WriteTable(output, export.Table{
+			Name:   "fade",
+			Blocks: steps,
+			Comment: func(i int) string {
+				return fmt.Sprintf("step %d", i)
+			},
+		})
+	}
+
+	for _, step := range steps {
+		_, err = output.Write(step)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// asmOptions returns the assembly output options, or nil if --asm-out
+// wasn't given.
+func asmOptions(args *Arguments) (*export.Options, error) {
+	opts, err := export.ParseOptions(args.AsmOutput, args.Radix)
+	if opts == nil || err != nil {
+		return nil, err
+	}
+
+	opts.PerLine = args.PerLine
+	opts.LabelPrefix = args.Label
+	opts.Comments = args.Comments
+	return opts, nil
+}
+
+func fadeNes(args *Arguments) ([][]byte, error) {
+	if args.PaletteFile != "" {
+		return nil, fmt.Errorf("Cannot use --pal-file with nes")
+	}
+
+	pal, err := parseNesColors(args.Colors)
+	if err != nil {
+		return nil, err
+	}
+
+	var fades [][]uint8
+	switch strings.ToLower(args.Target) {
+	case "black":
+		fades, err = palette.FadeNesToColor(pal, palette.NesBlack, args.Steps)
+	case "white":
+		fades, err = palette.FadeNesToColor(pal, palette.NesWhite, args.Steps)
+	default:
+		var target []uint8
+		target, err = parseNesColors(args.Target)
+		if err != nil {
+			return nil, err
+		}
+		fades, err = palette.FadeNes(pal, target, args.Steps)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	steps := [][]byte{}
+	for _, f := range fades {
+		steps = append(steps, f)
+	}
+	return steps, nil
+}
+
+func parseNesColors(str string) ([]uint8, error) {
+	if str == "" {
+		return nil, fmt.Errorf("Missing colors")
+	}
+
+	pal := []uint8{}
+	for _, s := range strings.Split(str, ",") {
+		c, err := palette.ParseNesColor(s)
+		if err != nil {
+			return nil, err
+		}
+		pal = append(pal, c)
+	}
+	return pal, nil
+}
+
+func fadeRGB(args *Arguments, cs palette.ColorSpace) ([][]byte, error) {
+	var pal color.Palette
+	var err error
+
+	if args.PaletteFile != "" && args.Colors != "" {
+		return nil, fmt.Errorf("Cannot use both --colors and --pal-file")
+	}
+
+	if args.PaletteFile != "" {
+		pal, err = palette.FromFile(args.PaletteFile, palette.PF_Gimp)
+	} else {
+		pal, err = parseHexColors(args.Colors)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var fades []color.Palette
+	switch strings.ToLower(args.Target) {
+	case "black":
+		fades, err = palette.FadeRGBToColor(cs, pal, color.Black, args.Steps)
+	case "white":
+		fades, err = palette.FadeRGBToColor(cs, pal, color.White, args.Steps)
+	default:
+		var target color.Palette
+		target, err = parseHexColors(args.Target)
+		if err != nil {
+			return nil, err
+		}
+		fades, err = palette.FadeRGB(cs, pal, target, args.Steps)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	steps := [][]byte{}
+	for _, f := range fades {
+		steps = append(steps, palette.EncodePalette(cs, f))
+	}
+	return steps, nil
+}
+
+func parseHexColors(str string) (color.Palette, error) {
+	if str == "" {
+		return nil, fmt.Errorf("Missing colors")
+	}
+
+	pal := color.Palette{}
+	for _, s := range strings.Split(str, ",") {
+		c, err := palette.ParseHexColor(s)
+		if err != nil {
+			return nil, err
+		}
+		pal = append(pal, c)
+	}
+	return pal, nil
+}
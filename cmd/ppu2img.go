@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+
+	snesimg "github.com/zorchenhimer/go-retroimg"
+	"github.com/zorchenhimer/go-retroimg/palette"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Arguments struct {
+	Output string `arg:"positional,required" help:"PNG to write"`
+
+	System string `arg:"--system" default:"nes" help:"nes or snes"`
+
+	// NES dumps.  Either a full dump of the PPU's address space, or the
+	// separate pieces.
+	PpuDump    string `arg:"--ppu-dump" help:"16K dump of PPU $0000-$3FFF.  Replaces --chr, --nametables, and --palette."`
+	Chr        string `arg:"--chr" help:"8K dump of both pattern tables."`
+	Nametables string `arg:"--nametables" help:"2K dump of nametable RAM, or 4K for four screen mirroring."`
+	Palette    string `arg:"--palette" help:"32 byte dump of palette RAM."`
+	Oam        string `arg:"--oam" help:"256 byte dump of OAM.  Sprites are drawn when this is given."`
+
+	Mirroring   rom.Mirroring `arg:"--mirroring" default:"vertical" help:"horizontal, vertical, single0, single1, or four.  Ignored with --ppu-dump."`
+	BgTable     int           `arg:"--bg-table" help:"Pattern table used for the background, 0 or 1."`
+	SpriteTable int           `arg:"--sprite-table" help:"Pattern table used for 8x8 sprites, 0 or 1."`
+	TallSprites bool          `arg:"--tall-sprites" help:"Draw sprites as 8x16."`
+	ScrollX     int           `arg:"--scroll-x" help:"Horizontal scroll, used to place sprites over the background."`
+	ScrollY     int           `arg:"--scroll-y" help:"Vertical scroll, used to place sprites over the background."`
+	NesPalFile  string        `arg:"--nes-pal-file" help:"64 color .pal file to use instead of the built in 2C02 colors."`
+
+	// SNES dumps.
+	Vram      string `arg:"--vram" help:"64K dump of SNES VRAM."`
+	Cgram     string `arg:"--cgram" help:"512 byte dump of CGRAM."`
+	Registers string `arg:"--registers" help:"Dump of the PPU registers starting at $2100.  BGMODE, BGnSC, and BGnnNBA are used."`
+	Layer     int    `arg:"--layer" default:"1" help:"SNES background layer to draw, 1-4."`
+}
+
+func main() {
+	args := &Arguments{}
+	arg.MustParse(args)
+
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args *Arguments) error {
+	var img image.Image
+	var err error
+
+	switch strings.ToLower(args.System) {
+	case "nes":
+		img, err = renderNes(args)
+	case "snes":
+		img, err = renderSnes(args)
+	default:
+		return fmt.Errorf("Unknown system: %q", args.System)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	outfile, err := os.Create(args.Output)
+	if err != nil {
+		return err
+	}
+	defer outfile.Close()
+
+	return png.Encode(outfile, img)
+}
+
+func renderNes(args *Arguments) (image.Image, error) {
+	if args.BgTable < 0 || args.BgTable > 1 || args.SpriteTable < 0 || args.SpriteTable > 1 {
+		return nil, fmt.Errorf("Pattern tables must be 0 or 1")
+	}
+
+	var ppu *snesimg.NesPpu
+
+	if args.PpuDump != "" {
+		if args.Chr != "" || args.Nametables != "" || args.Palette != "" {
+			return nil, fmt.Errorf("--ppu-dump can't be used with --chr, --nametables, or --palette")
+		}
+
+		dump, err := os.ReadFile(args.PpuDump)
+		if err != nil {
+			return nil, err
+		}
+
+		ppu, err = snesimg.NewNesPpuFromDump(dump)
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+		if args.Chr == "" || args.Nametables == "" || args.Palette == "" {
+			return nil, fmt.Errorf("--chr, --nametables, and --palette are required without --ppu-dump")
+		}
+
+		ppu = &snesimg.NesPpu{
+			Mirroring: args.Mirroring,
+			Colors:    palette.Nes_2C02,
+		}
+
+		var err error
+		ppu.Chr, err = os.ReadFile(args.Chr)
+		if err != nil {
+			return nil, err
+		}
+
+		ppu.Nametables, err = os.ReadFile(args.Nametables)
+		if err != nil {
+			return nil, err
+		}
+
+		pal, err := os.ReadFile(args.Palette)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(pal) != len(ppu.PaletteRam) {
+			return nil, fmt.Errorf("Palette RAM is %d bytes; expected %d", len(pal), len(ppu.PaletteRam))
+		}
+		copy(ppu.PaletteRam[:], pal)
+	}
+
+	// Only the lower six bits of palette RAM are stored.
+	for i := range ppu.PaletteRam {
+		ppu.PaletteRam[i] &= 0x3F
+	}
+
+	if args.Oam != "" {
+		var err error
+		ppu.Oam, err = os.ReadFile(args.Oam)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.NesPalFile != "" {
+		pal, err := palette.FromFile(args.NesPalFile, palette.PF_RawRGB)
+		if err != nil {
+			return nil, err
+		}
+		ppu.Colors = palette.NewColorMap(pal)
+	}
+
+	ppu.BgTable = args.BgTable
+	ppu.SpriteTable = args.SpriteTable
+	ppu.TallSprites = args.TallSprites
+
+	return ppu.Render(args.Oam != "", image.Pt(args.ScrollX, args.ScrollY))
+}
+
+func renderSnes(args *Arguments) (image.Image, error) {
+	if args.Vram == "" || args.Cgram == "" || args.Registers == "" {
+		return nil, fmt.Errorf("--vram, --cgram, and --registers are required for the SNES")
+	}
+
+	ppu := &snesimg.SnesPpu{}
+
+	var err error
+	ppu.Vram, err = os.ReadFile(args.Vram)
+	if err != nil {
+		return nil, err
+	}
+
+	ppu.Cgram, err = os.ReadFile(args.Cgram)
+	if err != nil {
+		return nil, err
+	}
+
+	ppu.Registers, err = os.ReadFile(args.Registers)
+	if err != nil {
+		return nil, err
+	}
+
+	return ppu.Layer(args.Layer)
+}
@@ -0,0 +1,83 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Codec is a compression format along with a decoder that works the same
+// way as the one running on the console.
+type Codec interface {
+	// Name used to select the codec on the command line.
+	Name() string
+
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress reads compressed data from the start of data.  The number
+	// of bytes read is returned along with the decompressed data.
+	Decompress(data []byte) ([]byte, int, error)
+}
+
+var codecs = map[string]Codec{}
+
+// Register makes a codec available to ByName().
+func Register(c Codec) {
+	codecs[strings.ToLower(c.Name())] = c
+}
+
+// ByName looks up a registered codec.
+func ByName(name string) (Codec, error) {
+	if c, ok := codecs[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("Unknown compression %q; expected one of %s", name, strings.Join(Names(), ", "))
+}
+
+// Names returns the names of every registered codec, sorted.
+func Names() []string {
+	names := []string{}
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RoundTrip compresses data and decompresses the result again, returning an
+// error if the output doesn't match the input.
+func RoundTrip(c Codec, data []byte) ([]byte, error) {
+	packed, err := c.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	unpacked, n, err := c.Decompress(packed)
+	if err != nil {
+		return nil, fmt.Errorf("%s round trip failed: %w", c.Name(), err)
+	}
+
+	if n != len(packed) {
+		return nil, fmt.Errorf("%s round trip failed: read %d of %d bytes", c.Name(), n, len(packed))
+	}
+
+	if !bytes.Equal(unpacked, data) {
+		return nil, fmt.Errorf("%s round trip failed: output does not match input", c.Name())
+	}
+
+	return packed, nil
+}
+
+// runLength returns the length of the run of identical bytes at the start of
+// data, up to max.
+func runLength(data []byte, max int) int {
+	n := 1
+	for n < len(data) && n < max && data[n] == data[0] {
+		n++
+	}
+	return n
+}
+
+var errTruncated = fmt.Errorf("compressed data is truncated")
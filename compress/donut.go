@@ -0,0 +1,357 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(Donut{})
+}
+
+// Donut is Johnathan Roatch's CHR codec.  Tiles are compressed in blocks of
+// four, 64 bytes, each split into eight 8 byte planes.  Even planes are the
+// low (L) planes and odd planes the high (M) planes.  Each block starts with
+// a header byte:
+//
+//	LMlmbbBR
+//	|||||||+-- Transpose each plane
+//	||||000--- All planes are 0x00
+//	||||010--- L planes are 0x00, M planes are pb8
+//	||||100--- L planes are pb8, M planes are 0x00
+//	||||110--- All planes are pb8
+//	||||001--- Another byte follows.  Bits set from the MSB down are pb8
+//	||||       planes, clear bits are 0x00 planes.
+//	||||011--- Another byte follows.  A single pb8 plane is copied to each
+//	||||       plane with its bit set.  No plane follows if the byte is 0.
+//	|||+------ M planes' pb8 starts from 0xFF
+//	||+------- L planes' pb8 starts from 0xFF
+//	|+-------- M = M XOR L
+//	+--------- L = M XOR L
+//
+// A header of 0x2A is followed by 64 uncompressed bytes.  Headers of 0xC0
+// and up are invalid, so at most one of the XOR bits is set.  A pb8 plane is a
+// flag byte followed by up to eight bytes.  For each flag bit from the MSB
+// down a set bit reads a new byte and a clear bit repeats the previous one.
+//
+// The whole input is always decompressed.  Input to Compress() has to be a
+// multiple of four tiles.
+type Donut struct{}
+
+const (
+	donutBlockSize    = 64
+	donutUncompressed = 0x2A
+)
+
+func (Donut) Name() string { return "donut" }
+
+func (Donut) Compress(data []byte) ([]byte, error) {
+	if len(data)%donutBlockSize != 0 {
+		return nil, fmt.Errorf("donut compresses blocks of %d bytes; got %d bytes", donutBlockSize, len(data))
+	}
+
+	out := []byte{}
+	for i := 0; i < len(data); i += donutBlockSize {
+		out = append(out, donutBlock(data[i:i+donutBlockSize])...)
+	}
+	return out, nil
+}
+
+// donutBlock tries every valid combination of the header's L and M bits and
+// keeps the smallest encoding.  Transposing is never used.
+func donutBlock(block []byte) []byte {
+	best := append([]byte{donutUncompressed}, block...)
+
+	for flags := 0; flags < 12; flags++ {
+		xorL, xorM := flags&0x08 != 0, flags&0x04 != 0
+		topL, topM := byte(0x00), byte(0x00)
+		if flags&0x02 != 0 {
+			topL = 0xFF
+		}
+		if flags&0x01 != 0 {
+			topM = 0xFF
+		}
+
+		planes := make([][]byte, 8)
+		for p := 0; p < 8; p += 2 {
+			l := make([]byte, 8)
+			m := make([]byte, 8)
+			for y := 0; y < 8; y++ {
+				l[y] = block[p*8+y]
+				m[y] = block[p*8+8+y]
+
+				// Undo what the decoder does with the XOR bits.
+				if xorL {
+					l[y] ^= m[y]
+				}
+				if xorM {
+					m[y] ^= l[y]
+				}
+			}
+			planes[p], planes[p+1] = l, m
+		}
+
+		header := byte(flags << 4)
+
+		encoded := make([][]byte, 8)
+		var mask byte
+		for p, plane := range planes {
+			if !isZero(plane) {
+				mask |= 0x80 >> p
+			}
+
+			top := topL
+			if p%2 == 1 {
+				top = topM
+			}
+			encoded[p] = pb8Encode(plane, top)
+		}
+
+		candidates := [][]byte{}
+
+		// Mask with every plane explicit.
+		c := []byte{header | 0x02, mask}
+		for p := range planes {
+			if mask&(0x80>>p) != 0 {
+				c = append(c, encoded[p]...)
+			}
+		}
+		candidates = append(candidates, c)
+
+		switch mask {
+		case 0x00:
+			candidates = append(candidates, []byte{header})
+		case 0x55:
+			c = []byte{header | 0x04}
+			for p := 1; p < 8; p += 2 {
+				c = append(c, encoded[p]...)
+			}
+			candidates = append(candidates, c)
+		case 0xAA:
+			c = []byte{header | 0x08}
+			for p := 0; p < 8; p += 2 {
+				c = append(c, encoded[p]...)
+			}
+			candidates = append(candidates, c)
+		case 0xFF:
+			c = []byte{header | 0x0C}
+			for _, e := range encoded {
+				c = append(c, e...)
+			}
+			candidates = append(candidates, c)
+		}
+
+		// A single plane copied around.  Only used when both kinds of
+		// plane start from the same value.
+		if topL == topM && mask != 0 {
+			var first []byte
+			same := true
+			for p, plane := range planes {
+				if mask&(0x80>>p) == 0 {
+					continue
+				}
+				if first == nil {
+					first = plane
+				} else if string(first) != string(plane) {
+					same = false
+					break
+				}
+			}
+
+			if same {
+				c = append([]byte{header | 0x06, mask}, pb8Encode(first, topL)...)
+				candidates = append(candidates, c)
+			}
+		}
+
+		for _, c := range candidates {
+			if len(c) < len(best) {
+				best = c
+			}
+		}
+	}
+
+	return best
+}
+
+func (Donut) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	for i := 0; i < len(data); {
+		header := data[i]
+		i++
+
+		if header >= 0xC0 {
+			return nil, 0, fmt.Errorf("invalid donut block header $%02X at offset %d", header, i-1)
+		}
+
+		if header == donutUncompressed {
+			if i+donutBlockSize > len(data) {
+				return nil, 0, errTruncated
+			}
+			out = append(out, data[i:i+donutBlockSize]...)
+			i += donutBlockSize
+			continue
+		}
+
+		topL, topM := byte(0x00), byte(0x00)
+		if header&0x20 != 0 {
+			topL = 0xFF
+		}
+		if header&0x10 != 0 {
+			topM = 0xFF
+		}
+
+		top := func(p int) byte {
+			if p%2 == 1 {
+				return topM
+			}
+			return topL
+		}
+
+		planes := make([][]byte, 8)
+		for p := range planes {
+			planes[p] = make([]byte, 8)
+		}
+
+		var err error
+		switch header & 0x0E {
+		case 0x00, 0x04, 0x08, 0x0C:
+			for p := range planes {
+				if (p%2 == 0 && header&0x08 != 0) || (p%2 == 1 && header&0x04 != 0) {
+					planes[p], i, err = pb8Decode(data, i, top(p))
+					if err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+
+		case 0x02:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			mask := data[i]
+			i++
+
+			for p := range planes {
+				if mask&(0x80>>p) != 0 {
+					planes[p], i, err = pb8Decode(data, i, top(p))
+					if err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+
+		case 0x06:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			mask := data[i]
+			i++
+
+			if mask != 0 {
+				// The first plane copied decides where pb8 starts from.
+				first := 0
+				for mask&(0x80>>first) == 0 {
+					first++
+				}
+
+				var plane []byte
+				plane, i, err = pb8Decode(data, i, top(first))
+				if err != nil {
+					return nil, 0, err
+				}
+
+				for p := range planes {
+					if mask&(0x80>>p) != 0 {
+						planes[p] = append([]byte{}, plane...)
+					}
+				}
+			}
+
+		default:
+			return nil, 0, fmt.Errorf("reserved donut block header $%02X at offset %d", header, i-1)
+		}
+
+		for p := 0; p < 8; p += 2 {
+			l, m := planes[p], planes[p+1]
+			if header&0x01 != 0 {
+				l, m = transposePlane(l), transposePlane(m)
+			}
+
+			for y := 0; y < 8; y++ {
+				if header&0x80 != 0 {
+					l[y] ^= m[y]
+				}
+				if header&0x40 != 0 {
+					m[y] ^= l[y]
+				}
+			}
+
+			out = append(out, l...)
+			out = append(out, m...)
+		}
+	}
+
+	return out, len(data), nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pb8Encode packs eight bytes into a flag byte followed by every byte that
+// differs from the one before it.
+func pb8Encode(plane []byte, top byte) []byte {
+	out := []byte{0}
+	prev := top
+	for i, b := range plane {
+		if b != prev {
+			out[0] |= 0x80 >> i
+			out = append(out, b)
+			prev = b
+		}
+	}
+	return out
+}
+
+func pb8Decode(data []byte, i int, top byte) ([]byte, int, error) {
+	if i >= len(data) {
+		return nil, 0, errTruncated
+	}
+
+	flags := data[i]
+	i++
+
+	plane := make([]byte, 8)
+	prev := top
+	for y := 0; y < 8; y++ {
+		if flags&(0x80>>y) != 0 {
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			prev = data[i]
+			i++
+		}
+		plane[y] = prev
+	}
+
+	return plane, i, nil
+}
+
+// transposePlane swaps the rows and columns of a plane.
+func transposePlane(plane []byte) []byte {
+	out := make([]byte, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if plane[y]&(0x80>>x) != 0 {
+				out[x] |= 0x80 >> y
+			}
+		}
+	}
+	return out
+}
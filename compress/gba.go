@@ -0,0 +1,320 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(GbaLz77{})
+	Register(GbaRle{})
+	Register(GbaHuffman{})
+}
+
+// Every GBA BIOS format starts with a 32-bit little endian word holding the
+// type in its low byte and the decompressed size in the upper 24 bits.
+const (
+	gbaLz77    = 0x10
+	gbaHuffman = 0x20
+	gbaRle     = 0x30
+
+	gbaMaxSize = 0xFFFFFF
+)
+
+func gbaHeader(kind byte, data []byte) ([]byte, error) {
+	if len(data) > gbaMaxSize {
+		return nil, fmt.Errorf("%d bytes is too large for a GBA BIOS header", len(data))
+	}
+
+	size := len(data)
+	return []byte{kind, byte(size), byte(size >> 8), byte(size >> 16)}, nil
+}
+
+// readGbaHeader returns the decompressed size if the header's type, with the
+// lower nibble masked off, matches kind.
+func readGbaHeader(data []byte, kind byte) (int, error) {
+	if len(data) < 4 {
+		return 0, errTruncated
+	}
+
+	if data[0]&0xF0 != kind {
+		return 0, fmt.Errorf("GBA BIOS header type $%02X is not $%02X", data[0], kind)
+	}
+
+	return int(data[1]) | int(data[2])<<8 | int(data[3])<<16, nil
+}
+
+// GbaLz77 is the format decoded by the GBA BIOS's LZ77UnCompWram and
+// LZ77UnCompVram calls.  After the header each flag byte covers the next
+// eight blocks, MSB first.  A clear bit is a literal byte and a set bit is
+// a two byte back reference:
+//
+//	LLLLDDDD DDDDDDDD
+//
+// The length is LLLL + 3 and the distance back DDDDDDDDDDDD + 1.  Compress()
+// never uses a distance of 1 so the output is safe to decompress to VRAM.
+type GbaLz77 struct{}
+
+const (
+	gbaLz77MinMatch = 3
+	gbaLz77MaxMatch = 15 + gbaLz77MinMatch
+	gbaLz77MaxDist  = 0x1000
+)
+
+func (GbaLz77) Name() string { return "gba-lz77" }
+
+func (GbaLz77) Compress(data []byte) ([]byte, error) {
+	out, err := gbaHeader(gbaLz77, data)
+	if err != nil {
+		return nil, err
+	}
+
+	flagPos := 0
+	bit := 8
+
+	for i := 0; i < len(data); {
+		if bit == 8 {
+			flagPos = len(out)
+			out = append(out, 0)
+			bit = 0
+		}
+
+		pos, length := longestMatch(data, i, 2, gbaLz77MaxDist, gbaLz77MaxMatch)
+		if length < gbaLz77MinMatch {
+			out = append(out, data[i])
+			i++
+			bit++
+			continue
+		}
+
+		out[flagPos] |= 0x80 >> bit
+		dist := i - pos - 1
+		out = append(out, byte(length-gbaLz77MinMatch)<<4|byte(dist>>8), byte(dist))
+		i += length
+		bit++
+	}
+
+	return out, nil
+}
+
+func (GbaLz77) Decompress(data []byte) ([]byte, int, error) {
+	size, err := readGbaHeader(data, gbaLz77)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := []byte{}
+	i := 4
+	for len(out) < size {
+		if i >= len(data) {
+			return nil, 0, errTruncated
+		}
+		flags := data[i]
+		i++
+
+		for bit := 0; bit < 8 && len(out) < size; bit++ {
+			if flags&(0x80>>bit) == 0 {
+				if i >= len(data) {
+					return nil, 0, errTruncated
+				}
+				out = append(out, data[i])
+				i++
+				continue
+			}
+
+			if i+1 >= len(data) {
+				return nil, 0, errTruncated
+			}
+
+			length := int(data[i]>>4) + gbaLz77MinMatch
+			dist := (int(data[i]&0x0F)<<8 | int(data[i+1])) + 1
+			i += 2
+
+			if dist > len(out) {
+				return nil, 0, fmt.Errorf("GBA LZ77 reference at byte %d is before the start of the data", i-2)
+			}
+
+			start := len(out) - dist
+			for n := 0; n < length && len(out) < size; n++ {
+				out = append(out, out[start+n])
+			}
+		}
+	}
+
+	return out, i, nil
+}
+
+// GbaRle is the format decoded by the GBA BIOS's RLUnCompWram and
+// RLUnCompVram calls.  After the header each block starts with a flag byte.
+// With bit 7 set the next byte is repeated (flag & $7F) + 3 times, otherwise
+// (flag & $7F) + 1 bytes are copied.
+type GbaRle struct{}
+
+const (
+	gbaRleMinRun     = 3
+	gbaRleMaxRun     = 0x7F + gbaRleMinRun
+	gbaRleMaxLiteral = 0x80
+)
+
+func (GbaRle) Name() string { return "gba-rle" }
+
+func (GbaRle) Compress(data []byte) ([]byte, error) {
+	out, err := gbaHeader(gbaRle, data)
+	if err != nil {
+		return nil, err
+	}
+
+	literal := []byte{}
+	flush := func() {
+		if len(literal) > 0 {
+			out = append(out, byte(len(literal)-1))
+			out = append(out, literal...)
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(data); {
+		n := runLength(data[i:], gbaRleMaxRun)
+		if n >= gbaRleMinRun {
+			flush()
+			out = append(out, 0x80|byte(n-gbaRleMinRun), data[i])
+			i += n
+			continue
+		}
+
+		literal = append(literal, data[i])
+		i++
+		if len(literal) == gbaRleMaxLiteral {
+			flush()
+		}
+	}
+	flush()
+
+	return out, nil
+}
+
+func (GbaRle) Decompress(data []byte) ([]byte, int, error) {
+	size, err := readGbaHeader(data, gbaRle)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := []byte{}
+	i := 4
+	for len(out) < size {
+		if i >= len(data) {
+			return nil, 0, errTruncated
+		}
+		flag := data[i]
+		i++
+
+		if flag&0x80 != 0 {
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < int(flag&0x7F)+gbaRleMinRun; n++ {
+				out = append(out, data[i])
+			}
+			i++
+			continue
+		}
+
+		n := int(flag&0x7F) + 1
+		if i+n > len(data) {
+			return nil, 0, errTruncated
+		}
+		out = append(out, data[i:i+n]...)
+		i += n
+	}
+
+	return out[:size], i, nil
+}
+
+// GbaHuffman is the format decoded by the GBA BIOS's HuffUnComp call.  The
+// lower nibble of the header's type is the size of each symbol, 4 or 8 bits.
+// A tree follows the header, then the bitstream as 32-bit little endian
+// words read from the MSB down.  Only decompression is supported.
+type GbaHuffman struct{}
+
+func (GbaHuffman) Name() string { return "gba-huffman" }
+
+func (GbaHuffman) Compress(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("gba-huffman can only decompress")
+}
+
+func (GbaHuffman) Decompress(data []byte) ([]byte, int, error) {
+	size, err := readGbaHeader(data, gbaHuffman)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	symbolBits := int(data[0] & 0x0F)
+	if symbolBits != 4 && symbolBits != 8 {
+		return nil, 0, fmt.Errorf("GBA Huffman symbols must be 4 or 8 bits; got %d", symbolBits)
+	}
+
+	if len(data) < 5 {
+		return nil, 0, errTruncated
+	}
+
+	// The tree's size byte counts pairs of bytes, including itself.
+	treeStart := 4
+	treeEnd := treeStart + (int(data[treeStart])+1)*2
+	if treeEnd > len(data) {
+		return nil, 0, errTruncated
+	}
+
+	out := []byte{}
+	var pending byte
+	havePending := false
+
+	i := treeEnd
+	node := treeStart + 1
+
+	for len(out) < size {
+		if i+4 > len(data) {
+			return nil, 0, errTruncated
+		}
+		word := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		i += 4
+
+		for bit := 31; bit >= 0 && len(out) < size; bit-- {
+			// Each node's lower six bits point to a pair of children.
+			// Bit 7 marks the first child as a symbol and bit 6 the
+			// second.
+			child := (node &^ 1) + int(data[node]&0x3F)*2 + 2
+			isSymbol := data[node]&0x80 != 0
+			if word&(1<<bit) != 0 {
+				child++
+				isSymbol = data[node]&0x40 != 0
+			}
+
+			if child >= treeEnd {
+				return nil, 0, fmt.Errorf("GBA Huffman tree node at byte %d points past the tree", node)
+			}
+
+			if !isSymbol {
+				node = child
+				continue
+			}
+
+			symbol := data[child]
+			node = treeStart + 1
+
+			if symbolBits == 8 {
+				out = append(out, symbol)
+				continue
+			}
+
+			// Nibbles fill each byte from the bottom.
+			if havePending {
+				out = append(out, pending|(symbol&0x0F)<<4)
+				havePending = false
+			} else {
+				pending = symbol & 0x0F
+				havePending = true
+			}
+		}
+	}
+
+	return out, i, nil
+}
@@ -0,0 +1,88 @@
+package compress
+
+func init() {
+	Register(Konami{})
+}
+
+// Konami is the RLE format used for nametables in many Konami games.  Each
+// block starts with a control byte:
+//
+//	$01-$80  repeat the next byte this many times
+//	$81-$FE  copy the next (control - $80) bytes
+//	$FF      end of data
+type Konami struct{}
+
+const (
+	konamiMaxRun     = 0x80
+	konamiMaxLiteral = 0xFE - 0x80
+	konamiEnd        = 0xFF
+)
+
+func (Konami) Name() string { return "konami" }
+
+func (Konami) Compress(data []byte) ([]byte, error) {
+	out := []byte{}
+	literal := []byte{}
+
+	flush := func() {
+		if len(literal) > 0 {
+			out = append(out, byte(0x80+len(literal)))
+			out = append(out, literal...)
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(data); {
+		n := runLength(data[i:], konamiMaxRun)
+
+		// Two bytes inside a literal cost the same as ending it for a run.
+		if n > 2 || (n == 2 && len(literal) == 0) {
+			flush()
+			out = append(out, byte(n), data[i])
+			i += n
+			continue
+		}
+
+		literal = append(literal, data[i])
+		i++
+		if len(literal) == konamiMaxLiteral {
+			flush()
+		}
+	}
+	flush()
+
+	return append(out, konamiEnd), nil
+}
+
+func (Konami) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	for i := 0; i < len(data); {
+		ctrl := data[i]
+		i++
+
+		switch {
+		case ctrl == konamiEnd:
+			return out, i, nil
+
+		case ctrl <= konamiMaxRun:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < int(ctrl); n++ {
+				out = append(out, data[i])
+			}
+			i++
+
+		default:
+			n := int(ctrl) - 0x80
+			if i+n > len(data) {
+				return nil, 0, errTruncated
+			}
+			out = append(out, data[i:i+n]...)
+			i += n
+		}
+	}
+
+	return nil, 0, errTruncated
+}
@@ -0,0 +1,359 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(LcLz2{})
+	Register(LcLz3{})
+}
+
+// LcLz2 and LcLz3 are named after Lunar Compress.  Both are made of
+// commands that start with a header byte:
+//
+//	CCCLLLLL           command C, length L + 1
+//	111CCCLL LLLLLLLL  command C, 10-bit length L + 1
+//
+// A header of $FF ends the data.
+const (
+	lunarEnd       = 0xFF
+	lunarLong      = 7
+	lunarMaxLength = 1024
+
+	// Back references only look this far for a match.
+	lunarSearch = 0x1000
+)
+
+func lunarHeader(cmd, length int) []byte {
+	if length <= 32 {
+		return []byte{byte(cmd<<5 | (length - 1))}
+	}
+
+	return []byte{byte(lunarLong<<5 | cmd<<2 | (length-1)>>8), byte(length - 1)}
+}
+
+// lunarCommand reads the header at data[i] and returns the command, its
+// length, and the index of the first byte after the header.
+func lunarCommand(data []byte, i int) (int, int, int, error) {
+	cmd := int(data[i] >> 5)
+	length := int(data[i]&0x1F) + 1
+	i++
+
+	if cmd == lunarLong {
+		if i >= len(data) {
+			return 0, 0, 0, errTruncated
+		}
+
+		cmd = int(data[i-1]>>2) & 0x07
+		length = (int(data[i-1]&0x03)<<8 | int(data[i])) + 1
+		i++
+	}
+
+	return cmd, length, i, nil
+}
+
+// lunarRun is a candidate command and the number of bytes of output it
+// covers.
+type lunarRun struct {
+	cmd    []byte
+	length int
+}
+
+// lunarCompress writes literals until one of the candidate commands
+// returned by next saves space.
+func lunarCompress(data []byte, next func(i int) []lunarRun) []byte {
+	out := []byte{}
+	literal := []byte{}
+
+	flush := func() {
+		if len(literal) > 0 {
+			out = append(out, lunarHeader(0, len(literal))...)
+			out = append(out, literal...)
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(data); {
+		var best *lunarRun
+		for _, run := range next(i) {
+			if run.length <= len(run.cmd) {
+				continue
+			}
+
+			if best == nil || run.length-len(run.cmd) > best.length-len(best.cmd) {
+				r := run
+				best = &r
+			}
+		}
+
+		if best == nil {
+			literal = append(literal, data[i])
+			i++
+			if len(literal) == lunarMaxLength {
+				flush()
+			}
+			continue
+		}
+
+		flush()
+		out = append(out, best.cmd...)
+		i += best.length
+	}
+	flush()
+
+	return append(out, lunarEnd)
+}
+
+// fillRuns returns candidates for the byte, word, and increasing fill
+// commands that LcLz2 and LcLz3 share.  LcLz3 has no increasing fill.
+func fillRuns(data []byte, i int, increasing bool) []lunarRun {
+	max := min(len(data)-i, lunarMaxLength)
+	runs := []lunarRun{}
+
+	n := runLength(data[i:], max)
+	runs = append(runs, lunarRun{append(lunarHeader(1, n), data[i]), n})
+
+	if max >= 2 {
+		n = 2
+		for n < max && data[i+n] == data[i+n%2] {
+			n++
+		}
+		runs = append(runs, lunarRun{append(lunarHeader(2, n), data[i], data[i+1]), n})
+	}
+
+	if increasing {
+		n = 1
+		for n < max && data[i+n] == data[i]+byte(n) {
+			n++
+		}
+		runs = append(runs, lunarRun{append(lunarHeader(3, n), data[i]), n})
+	}
+
+	return runs
+}
+
+// LcLz2 is used by Super Mario World, among others.  Commands:
+//
+//	0  copy L bytes
+//	1  repeat one byte L times
+//	2  alternate two bytes for L bytes
+//	3  one byte, incremented after each of L bytes
+//	4  copy L bytes of output from a big endian 16-bit address
+type LcLz2 struct{}
+
+func (LcLz2) Name() string { return "lc-lz2" }
+
+func (LcLz2) Compress(data []byte) ([]byte, error) {
+	return lunarCompress(data, func(i int) []lunarRun {
+		runs := fillRuns(data, i, true)
+
+		pos, n := longestMatch(data, i, 1, lunarSearch, lunarMaxLength)
+		if n > 0 && pos <= 0xFFFF {
+			runs = append(runs, lunarRun{append(lunarHeader(4, n), byte(pos>>8), byte(pos)), n})
+		}
+
+		return runs
+	}), nil
+}
+
+func (LcLz2) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	for i := 0; i < len(data); {
+		if data[i] == lunarEnd {
+			return out, i + 1, nil
+		}
+
+		cmd, length, next, err := lunarCommand(data, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		i = next
+
+		switch cmd {
+		case 0:
+			if i+length > len(data) {
+				return nil, 0, errTruncated
+			}
+			out = append(out, data[i:i+length]...)
+			i += length
+
+		case 1, 3:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < length; n++ {
+				if cmd == 1 {
+					out = append(out, data[i])
+				} else {
+					out = append(out, data[i]+byte(n))
+				}
+			}
+			i++
+
+		case 2:
+			if i+1 >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < length; n++ {
+				out = append(out, data[i+n%2])
+			}
+			i += 2
+
+		case 4:
+			if i+1 >= len(data) {
+				return nil, 0, errTruncated
+			}
+			addr := int(data[i])<<8 | int(data[i+1])
+			i += 2
+
+			if addr >= len(out) {
+				return nil, 0, fmt.Errorf("LC_LZ2 copy from $%04X at byte %d is past the end of the output", addr, i-2)
+			}
+			for n := 0; n < length; n++ {
+				out = append(out, out[addr+n])
+			}
+
+		default:
+			return nil, 0, fmt.Errorf("invalid LC_LZ2 command %d at byte %d", cmd, i-1)
+		}
+	}
+
+	return nil, 0, errTruncated
+}
+
+// LcLz3 is used by Pokémon Gold, Silver, and Crystal.  Commands:
+//
+//	0  copy L bytes
+//	1  repeat one byte L times
+//	2  alternate two bytes for L bytes
+//	3  write L zeros
+//	4  copy L bytes of output
+//	5  copy L bytes of output with their bits reversed
+//	6  copy L bytes of output, going backwards
+//
+// Commands 4-6 are followed by where to copy from.  With bit 7 set the lower
+// seven bits count back from the end of the output, starting at 1.
+// Otherwise it's a big endian 15-bit address.
+type LcLz3 struct{}
+
+func (LcLz3) Name() string { return "lc-lz3" }
+
+func (LcLz3) Compress(data []byte) ([]byte, error) {
+	return lunarCompress(data, func(i int) []lunarRun {
+		runs := fillRuns(data, i, false)
+
+		if data[i] == 0 {
+			n := runLength(data[i:], lunarMaxLength)
+			runs = append(runs, lunarRun{lunarHeader(3, n), n})
+		}
+
+		pos, n := longestMatch(data, i, 1, lunarSearch, lunarMaxLength)
+		switch {
+		case n == 0:
+		case i-pos <= 0x80:
+			runs = append(runs, lunarRun{append(lunarHeader(4, n), 0x80|byte(i-pos-1)), n})
+		case pos <= 0x7FFF:
+			runs = append(runs, lunarRun{append(lunarHeader(4, n), byte(pos>>8), byte(pos)), n})
+		}
+
+		return runs
+	}), nil
+}
+
+func (LcLz3) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	for i := 0; i < len(data); {
+		if data[i] == lunarEnd {
+			return out, i + 1, nil
+		}
+
+		cmd, length, next, err := lunarCommand(data, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		i = next
+
+		switch cmd {
+		case 0:
+			if i+length > len(data) {
+				return nil, 0, errTruncated
+			}
+			out = append(out, data[i:i+length]...)
+			i += length
+
+		case 1:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < length; n++ {
+				out = append(out, data[i])
+			}
+			i++
+
+		case 2:
+			if i+1 >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < length; n++ {
+				out = append(out, data[i+n%2])
+			}
+			i += 2
+
+		case 3:
+			for n := 0; n < length; n++ {
+				out = append(out, 0)
+			}
+
+		case 4, 5, 6:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+
+			var addr int
+			if data[i]&0x80 != 0 {
+				addr = len(out) - int(data[i]&0x7F) - 1
+				i++
+			} else {
+				if i+1 >= len(data) {
+					return nil, 0, errTruncated
+				}
+				addr = int(data[i])<<8 | int(data[i+1])
+				i += 2
+			}
+
+			for n := 0; n < length; n++ {
+				src := addr + n
+				if cmd == 6 {
+					src = addr - n
+				}
+
+				if src < 0 || src >= len(out) {
+					return nil, 0, fmt.Errorf("LC_LZ3 copy at byte %d reads outside of the output", i)
+				}
+
+				b := out[src]
+				if cmd == 5 {
+					b = reverseBits(b)
+				}
+				out = append(out, b)
+			}
+
+		default:
+			return nil, 0, fmt.Errorf("invalid LC_LZ3 command %d at byte %d", cmd, i-1)
+		}
+	}
+
+	return nil, 0, errTruncated
+}
+
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b&0x01
+		b >>= 1
+	}
+	return r
+}
@@ -0,0 +1,282 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(Lzss{})
+	Register(Lz4{})
+}
+
+// Lzss is Haruhiko Okumura's LZSS format.  Each flag byte covers the next
+// eight items, LSB first.  A set bit is a literal byte and a clear bit is a
+// two byte reference into a 4K ring buffer:
+//
+//	pppppppp PPPPllll
+//
+// The position is PPPPpppppppp and the length is llll + 3.  The ring buffer
+// starts filled with spaces and writing starts at $FEE.  The whole input is
+// always decompressed.
+type Lzss struct{}
+
+const (
+	lzssRingSize  = 4096
+	lzssRingStart = lzssRingSize - 18
+	lzssMinMatch  = 3
+	lzssMaxMatch  = 15 + lzssMinMatch
+)
+
+func (Lzss) Name() string { return "lzss" }
+
+func (Lzss) Compress(data []byte) ([]byte, error) {
+	out := []byte{}
+
+	flagPos := -1
+	bit := 8
+
+	item := func() {
+		if bit == 8 {
+			flagPos = len(out)
+			out = append(out, 0)
+			bit = 0
+		}
+		bit++
+	}
+
+	for i := 0; i < len(data); {
+		pos, length := longestMatch(data, i, 1, lzssRingSize-lzssMaxMatch, lzssMaxMatch)
+
+		item()
+		if length < lzssMinMatch {
+			out[flagPos] |= 1 << (bit - 1)
+			out = append(out, data[i])
+			i++
+			continue
+		}
+
+		ring := (lzssRingStart + pos) % lzssRingSize
+		out = append(out, byte(ring), byte(ring>>8)<<4|byte(length-lzssMinMatch))
+		i += length
+	}
+
+	return out, nil
+}
+
+func (Lzss) Decompress(data []byte) ([]byte, int, error) {
+	ring := make([]byte, lzssRingSize)
+	for i := range ring {
+		ring[i] = ' '
+	}
+	r := lzssRingStart
+
+	out := []byte{}
+	write := func(b byte) {
+		out = append(out, b)
+		ring[r] = b
+		r = (r + 1) % lzssRingSize
+	}
+
+	for i := 0; i < len(data); {
+		flags := data[i]
+		i++
+
+		for bit := 0; bit < 8 && i < len(data); bit++ {
+			if flags&(1<<bit) != 0 {
+				write(data[i])
+				i++
+				continue
+			}
+
+			if i+1 >= len(data) {
+				return nil, 0, errTruncated
+			}
+
+			pos := int(data[i]) | int(data[i+1]&0xF0)<<4
+			length := int(data[i+1]&0x0F) + lzssMinMatch
+			i += 2
+
+			for n := 0; n < length; n++ {
+				write(ring[(pos+n)%lzssRingSize])
+			}
+		}
+	}
+
+	return out, len(data), nil
+}
+
+// Lz4 is the LZ4 block format.  Each sequence starts with a token whose
+// upper nibble is the number of literals and lower nibble the match length
+// minus four.  A nibble of 15 continues in the following bytes.  Literals
+// come next, then a little endian offset back into the output.  The last
+// sequence is only literals and ends the input.
+type Lz4 struct{}
+
+const (
+	lz4MinMatch = 4
+	lz4MaxDist  = 0xFFFF
+
+	// The format requires the last five bytes to be literals and the last
+	// match to start at least 12 bytes from the end.
+	lz4LastLiterals = 5
+	lz4MatchLimit   = 12
+)
+
+func (Lz4) Name() string { return "lz4" }
+
+func (Lz4) Compress(data []byte) ([]byte, error) {
+	out := []byte{}
+
+	length := func(n int) {
+		for ; n >= 255; n -= 255 {
+			out = append(out, 255)
+		}
+		out = append(out, byte(n))
+	}
+
+	sequence := func(literals []byte, offset, match int) {
+		token := byte(0)
+		if len(literals) >= 15 {
+			token = 0xF0
+		} else {
+			token = byte(len(literals)) << 4
+		}
+
+		if match > 0 {
+			if match-lz4MinMatch >= 15 {
+				token |= 0x0F
+			} else {
+				token |= byte(match - lz4MinMatch)
+			}
+		}
+
+		out = append(out, token)
+		if len(literals) >= 15 {
+			length(len(literals) - 15)
+		}
+		out = append(out, literals...)
+
+		if match > 0 {
+			out = append(out, byte(offset), byte(offset>>8))
+			if match-lz4MinMatch >= 15 {
+				length(match - lz4MinMatch - 15)
+			}
+		}
+	}
+
+	anchor := 0
+	for i := 0; i+lz4MatchLimit <= len(data); {
+		pos, n := longestMatch(data, i, 1, lz4MaxDist, len(data)-lz4LastLiterals-i)
+		if n < lz4MinMatch {
+			i++
+			continue
+		}
+
+		sequence(data[anchor:i], i-pos, n)
+		i += n
+		anchor = i
+	}
+	sequence(data[anchor:], 0, 0)
+
+	return out, nil
+}
+
+func (Lz4) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	length := func(i, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+
+		for {
+			if i >= len(data) {
+				return 0, 0, errTruncated
+			}
+			b := data[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(data); {
+		token := data[i]
+		i++
+
+		var literals int
+		var err error
+		i, literals, err = length(i, int(token>>4))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if i+literals > len(data) {
+			return nil, 0, errTruncated
+		}
+		out = append(out, data[i:i+literals]...)
+		i += literals
+
+		// The last sequence has no match.
+		if i == len(data) {
+			break
+		}
+
+		if i+1 >= len(data) {
+			return nil, 0, errTruncated
+		}
+		offset := int(data[i]) | int(data[i+1])<<8
+		i += 2
+
+		if offset == 0 || offset > len(out) {
+			return nil, 0, fmt.Errorf("invalid LZ4 match offset %d at byte %d", offset, i-2)
+		}
+
+		var match int
+		i, match, err = length(i, int(token&0x0F))
+		if err != nil {
+			return nil, 0, err
+		}
+		match += lz4MinMatch
+
+		start := len(out) - offset
+		for n := 0; n < match; n++ {
+			out = append(out, out[start+n])
+		}
+	}
+
+	return out, len(data), nil
+}
+
+// longestMatch finds the longest run of bytes before data[i] that matches
+// the bytes at i, searching back from minDist to maxDist bytes.  Matches may
+// overlap i.  The start of the match and its length are returned.
+func longestMatch(data []byte, i, minDist, maxDist, maxLen int) (int, int) {
+	if maxLen > len(data)-i {
+		maxLen = len(data) - i
+	}
+
+	start := i - maxDist
+	if start < 0 {
+		start = 0
+	}
+
+	bestPos, bestLen := 0, 0
+	for pos := i - minDist; pos >= start; pos-- {
+		n := 0
+		for n < maxLen && data[pos+n] == data[i+n] {
+			n++
+		}
+
+		if n > bestLen {
+			bestPos, bestLen = pos, n
+			if n == maxLen {
+				break
+			}
+		}
+	}
+
+	return bestPos, bestLen
+}
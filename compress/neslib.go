@@ -0,0 +1,91 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(NesLib{})
+}
+
+// NesLib is the RLE format decoded by vram_unrle in Shiru's neslib and
+// written by NES Screen Tool.  The first byte is a tag that isn't used
+// anywhere in the data.  Every other byte is written as is, except the tag,
+// which is followed by a count of times to repeat the previous byte.  A count
+// of zero ends the data.
+type NesLib struct{}
+
+func (NesLib) Name() string { return "neslib" }
+
+func (NesLib) Compress(data []byte) ([]byte, error) {
+	used := [256]int{}
+	for _, b := range data {
+		used[b]++
+	}
+
+	tag := -1
+	for i, count := range used {
+		if count == 0 {
+			tag = i
+			break
+		}
+	}
+
+	if tag == -1 {
+		return nil, fmt.Errorf("neslib RLE needs a byte value that isn't in the data")
+	}
+
+	out := []byte{byte(tag)}
+	for i := 0; i < len(data); {
+		n := runLength(data[i:], 256)
+		out = append(out, data[i])
+
+		// A repeat costs two bytes so a single repeat is written as is.
+		switch {
+		case n == 2:
+			out = append(out, data[i])
+		case n > 2:
+			out = append(out, byte(tag), byte(n-1))
+		}
+		i += n
+	}
+
+	return append(out, byte(tag), 0), nil
+}
+
+func (NesLib) Decompress(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errTruncated
+	}
+
+	tag := data[0]
+	out := []byte{}
+
+	var last byte
+	for i := 1; i < len(data); i++ {
+		if data[i] != tag {
+			last = data[i]
+			out = append(out, last)
+			continue
+		}
+
+		i++
+		if i >= len(data) {
+			break
+		}
+
+		if data[i] == 0 {
+			return out, i + 1, nil
+		}
+
+		if len(out) == 0 {
+			return nil, 0, fmt.Errorf("neslib RLE repeat at offset %d has no byte to repeat", i-1)
+		}
+
+		for n := 0; n < int(data[i]); n++ {
+			out = append(out, last)
+		}
+	}
+
+	return nil, 0, errTruncated
+}
@@ -0,0 +1,83 @@
+package compress
+
+func init() {
+	Register(PackBits{})
+}
+
+// PackBits is Apple's RLE format, decoded by many homebrew NES libraries.
+// Each block starts with a signed header byte:
+//
+//	0 to 127     copy the next (header + 1) bytes
+//	-1 to -127   repeat the next byte (1 - header) times
+//	-128         skipped
+//
+// There is no end marker so the whole input is always decompressed.
+type PackBits struct{}
+
+func (PackBits) Name() string { return "packbits" }
+
+func (PackBits) Compress(data []byte) ([]byte, error) {
+	out := []byte{}
+	literal := []byte{}
+
+	flush := func() {
+		if len(literal) > 0 {
+			out = append(out, byte(len(literal)-1))
+			out = append(out, literal...)
+			literal = literal[:0]
+		}
+	}
+
+	for i := 0; i < len(data); {
+		n := runLength(data[i:], 128)
+
+		if n > 2 || (n == 2 && len(literal) == 0) {
+			flush()
+			out = append(out, byte(1-n), data[i])
+			i += n
+			continue
+		}
+
+		literal = append(literal, data[i])
+		i++
+		if len(literal) == 128 {
+			flush()
+		}
+	}
+	flush()
+
+	return out, nil
+}
+
+func (PackBits) Decompress(data []byte) ([]byte, int, error) {
+	out := []byte{}
+
+	for i := 0; i < len(data); {
+		header := int8(data[i])
+		i++
+
+		switch {
+		case header == -128:
+			// no-op
+
+		case header >= 0:
+			n := int(header) + 1
+			if i+n > len(data) {
+				return nil, 0, errTruncated
+			}
+			out = append(out, data[i:i+n]...)
+			i += n
+
+		default:
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			for n := 0; n < 1-int(header); n++ {
+				out = append(out, data[i])
+			}
+			i++
+		}
+	}
+
+	return out, len(data), nil
+}
@@ -0,0 +1,230 @@
+package compress
+
+import (
+	"fmt"
+)
+
+func init() {
+	Register(PokemonGen1{})
+}
+
+// PokemonGen1 is the picture format used by Pokémon Red, Blue, and Yellow.
+// The first byte holds the width and height in tiles, in the upper and
+// lower nibbles.  Each bit plane is then run length encoded in pairs of
+// bits, going down two pixel wide columns, and may be delta coded along each
+// row or XORed with the other plane.  Decompressed tiles are 2bpp Game Boy
+// tiles in column-major order, the same as they end up in VRAM.  Only
+// decompression is supported.
+type PokemonGen1 struct{}
+
+func (PokemonGen1) Name() string { return "pokemon-gen1" }
+
+func (PokemonGen1) Compress(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("pokemon-gen1 can only decompress")
+}
+
+// bitReader reads bits from the MSB of each byte down.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (br *bitReader) bit() (int, error) {
+	if br.pos/8 >= len(br.data) {
+		return 0, errTruncated
+	}
+
+	b := int(br.data[br.pos/8]>>(7-br.pos%8)) & 0x01
+	br.pos++
+	return b, nil
+}
+
+func (br *bitReader) bits(n int) (int, error) {
+	val := 0
+	for i := 0; i < n; i++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		val = val<<1 | b
+	}
+	return val, nil
+}
+
+// Bytes read so far, counting a partly read byte.
+func (br *bitReader) consumed() int {
+	return (br.pos + 7) / 8
+}
+
+func (PokemonGen1) Decompress(data []byte) ([]byte, int, error) {
+	br := &bitReader{data: data}
+
+	width, err := br.bits(4)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	height, err := br.bits(4)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if width == 0 || height == 0 {
+		return nil, 0, fmt.Errorf("invalid picture size %dx%d", width, height)
+	}
+
+	rows := height * 8
+
+	// The plane filled first.
+	first, err := br.bit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	planes := [2][]byte{}
+	planes[first], err = pokemonPlane(br, width, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 0, 10, or 11
+	mode, err := br.bit()
+	if err != nil {
+		return nil, 0, err
+	}
+	if mode == 1 {
+		b, err := br.bit()
+		if err != nil {
+			return nil, 0, err
+		}
+		mode += b
+	}
+
+	second := first ^ 1
+	planes[second], err = pokemonPlane(br, width, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch mode {
+	case 0:
+		pokemonDelta(planes[0], width, rows)
+		pokemonDelta(planes[1], width, rows)
+	case 1:
+		pokemonDelta(planes[first], width, rows)
+		pokemonXor(planes[second], planes[first])
+	case 2:
+		pokemonDelta(planes[second], width, rows)
+		pokemonDelta(planes[first], width, rows)
+		pokemonXor(planes[second], planes[first])
+	}
+
+	out := []byte{}
+	for i := range planes[0] {
+		out = append(out, planes[0][i], planes[1][i])
+	}
+
+	return out, br.consumed(), nil
+}
+
+// pokemonPlane reads one bit plane.  Runs of zero pairs alternate with
+// packets of non-zero pairs that end at a zero pair.  The first bit says
+// which comes first.  The plane is returned a byte per row of each tile
+// column, top to bottom, one column after another.
+func pokemonPlane(br *bitReader, width, rows int) ([]byte, error) {
+	total := width * 4 * rows
+
+	bit, err := br.bit()
+	if err != nil {
+		return nil, err
+	}
+	zeros := bit == 0
+
+	pairs := []byte{}
+	for len(pairs) < total {
+		if zeros {
+			// The count is n bits long, where n is one more than the
+			// number of ones in front of it.
+			n := 0
+			for {
+				b, err := br.bit()
+				if err != nil {
+					return nil, err
+				}
+				if b == 0 {
+					break
+				}
+
+				n++
+				if n > 16 {
+					return nil, fmt.Errorf("invalid run length at bit %d", br.pos)
+				}
+			}
+
+			count, err := br.bits(n + 1)
+			if err != nil {
+				return nil, err
+			}
+			count += (2 << n) - 1
+
+			if len(pairs)+count > total {
+				return nil, fmt.Errorf("run at bit %d goes past the end of the plane", br.pos)
+			}
+
+			for i := 0; i < count; i++ {
+				pairs = append(pairs, 0)
+			}
+
+		} else {
+			for len(pairs) < total {
+				p, err := br.bits(2)
+				if err != nil {
+					return nil, err
+				}
+				if p == 0 {
+					break
+				}
+				pairs = append(pairs, byte(p))
+			}
+		}
+
+		zeros = !zeros
+	}
+
+	// Pairs go down each two pixel column.  Four columns make a byte.
+	plane := make([]byte, width*rows)
+	for col := 0; col < width; col++ {
+		for row := 0; row < rows; row++ {
+			var b byte
+			for j := 0; j < 4; j++ {
+				b = b<<2 | pairs[(col*4+j)*rows+row]
+			}
+			plane[col*rows+row] = b
+		}
+	}
+
+	return plane, nil
+}
+
+// pokemonDelta undoes the delta coding along each row.  A set bit flips the
+// pixel from the one to its left and a clear bit keeps it.
+func pokemonDelta(plane []byte, width, rows int) {
+	for row := 0; row < rows; row++ {
+		bit := byte(0)
+		for col := 0; col < width; col++ {
+			in := plane[col*rows+row]
+			var out byte
+			for k := 7; k >= 0; k-- {
+				bit ^= (in >> k) & 0x01
+				out |= bit << k
+			}
+			plane[col*rows+row] = out
+		}
+	}
+}
+
+func pokemonXor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
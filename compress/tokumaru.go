@@ -0,0 +1,394 @@
+package compress
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Tokumaru is tokumaru's tile codec for 2bpp NES tiles.  Each pixel is coded
+// by how it follows the pixel to its left, using a list of the colors that
+// may follow each color.
+//
+// The first byte is the number of tiles, with 0 meaning 256.  The rest is a
+// stream of bits, read from the MSB of each byte down.  Tiles are grouped in
+// blocks that each start with the colors that may follow each color, from
+// color 3 down to color 0:
+//
+//	2 bits     number of colors that may follow, 0 to 3
+//	2 bits     each of those colors, except the third which is the color
+//	           that is left over
+//
+// Each row of a tile is then either a 1 bit to repeat the row before it, or
+// a 0 bit followed by the row's first pixel in 2 bits.  Each pixel after the
+// first is coded against the one to its left:
+//
+//	0    same color
+//	10   first color that may follow (1 when there is only one)
+//	110  second color (11 when there are only two)
+//	111  third color
+//
+// Pixels following a color that nothing may follow take no bits.  After
+// every tile but the last, a 1 bit starts a new block and a 0 bit keeps the
+// current one.  The row before the first row is all color 0.
+//
+// Input to Compress() has to be whole tiles, from 1 to 256 of them.
+//
+// This isn't registered with the other codecs yet.  The format above hasn't
+// been checked against tokumaru's reference compressor and decompressor, and
+// a 6502 decompressor has to be able to read what it writes.
+type Tokumaru struct{}
+
+const tokumaruTileSize = 16
+
+func (Tokumaru) Name() string { return "tokumaru" }
+
+// tokumaruRow is the colors of a row of pixels, left to right.
+type tokumaruRow [8]uint8
+
+// tokumaruCounts is the number of times each color is followed by each other
+// color in the rows of a block that aren't repeated.
+type tokumaruCounts [4][4]int
+
+func (counts *tokumaruCounts) add(other tokumaruCounts) {
+	for p := range counts {
+		for q := range counts[p] {
+			counts[p][q] += other[p][q]
+		}
+	}
+}
+
+// followers returns the colors that follow each color, most common first.
+func (counts tokumaruCounts) followers() [4][]uint8 {
+	lists := [4][]uint8{}
+	for p := range counts {
+		for q := range counts[p] {
+			if q != p && counts[p][q] > 0 {
+				lists[p] = append(lists[p], uint8(q))
+			}
+		}
+
+		sort.SliceStable(lists[p], func(i, j int) bool {
+			return counts[p][lists[p][i]] > counts[p][lists[p][j]]
+		})
+	}
+	return lists
+}
+
+// bits is the size of a block's header and the pixels after the first in
+// each of its coded rows.
+func (counts tokumaruCounts) bits() int {
+	lists := counts.followers()
+	total := 0
+	for p, list := range lists {
+		total += 2 + 2*min(len(list), 2)
+		if len(list) > 0 {
+			total += counts[p][p]
+		}
+
+		for i, q := range list {
+			total += counts[p][q] * tokumaruCodeBits(len(list), i)
+		}
+	}
+	return total
+}
+
+// tokumaruCodeBits is the size of the code for the follower at index i of a
+// list of n followers.
+func tokumaruCodeBits(n, i int) int {
+	switch {
+	case n == 1:
+		return 1
+	case n == 3 && i > 0:
+		return 3
+	}
+	return 2
+}
+
+func (Tokumaru) Compress(data []byte) ([]byte, error) {
+	if len(data)%tokumaruTileSize != 0 {
+		return nil, fmt.Errorf("tokumaru compresses whole %d byte tiles; got %d bytes", tokumaruTileSize, len(data))
+	}
+
+	count := len(data) / tokumaruTileSize
+	if count < 1 || count > 256 {
+		return nil, fmt.Errorf("tokumaru compresses 1 to 256 tiles; got %d", count)
+	}
+
+	// Rows of each tile, whether each row repeats the one before it, and
+	// the colors that follow each other in the rest.
+	rows := make([][8]tokumaruRow, count)
+	repeats := make([][8]bool, count)
+	counts := make([]tokumaruCounts, count)
+
+	prev := tokumaruRow{}
+	for t := range rows {
+		tile := data[t*tokumaruTileSize:]
+		for y := 0; y < 8; y++ {
+			row := tokumaruRow{}
+			for x := 0; x < 8; x++ {
+				row[x] = (tile[y]>>(7-x))&0x01 | ((tile[8+y]>>(7-x))&0x01)<<1
+			}
+
+			rows[t][y] = row
+			if row == prev {
+				repeats[t][y] = true
+				continue
+			}
+
+			for x := 1; x < 8; x++ {
+				counts[t][row[x-1]][row[x]]++
+			}
+			prev = row
+		}
+	}
+
+	// Tiles join the current block unless starting a new one is smaller.
+	starts := make([]bool, count)
+	starts[0] = true
+	block := counts[0]
+	for t := 1; t < count; t++ {
+		joined := block
+		joined.add(counts[t])
+
+		if block.bits()+counts[t].bits() < joined.bits() {
+			starts[t] = true
+			block = counts[t]
+		} else {
+			block = joined
+		}
+	}
+
+	bw := &bitWriter{}
+	var lists [4][]uint8
+	for t := range rows {
+		if t > 0 {
+			bw.bool(starts[t])
+		}
+
+		if starts[t] {
+			block := tokumaruCounts{}
+			for b := t; b < count && (b == t || !starts[b]); b++ {
+				block.add(counts[b])
+			}
+
+			lists = block.followers()
+			for p := 3; p >= 0; p-- {
+				bw.bits(len(lists[p]), 2)
+				for i, q := range lists[p] {
+					if i < 2 {
+						bw.bits(int(q), 2)
+					}
+				}
+			}
+		}
+
+		for y, row := range rows[t] {
+			bw.bool(repeats[t][y])
+			if repeats[t][y] {
+				continue
+			}
+
+			bw.bits(int(row[0]), 2)
+			for x := 1; x < 8; x++ {
+				tokumaruWritePixel(bw, lists[row[x-1]], row[x-1], row[x])
+			}
+		}
+	}
+
+	return append([]byte{byte(count)}, bw.data...), nil
+}
+
+func tokumaruWritePixel(bw *bitWriter, list []uint8, prev, color uint8) {
+	if len(list) == 0 {
+		return
+	}
+
+	if color == prev {
+		bw.bit(0)
+		return
+	}
+
+	bw.bit(1)
+	for i, q := range list {
+		if i == len(list)-1 {
+			return
+		}
+
+		if q == color {
+			bw.bit(0)
+			return
+		}
+		bw.bit(1)
+	}
+}
+
+func (Tokumaru) Decompress(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errTruncated
+	}
+
+	count := int(data[0])
+	if count == 0 {
+		count = 256
+	}
+
+	br := &bitReader{data: data[1:]}
+	out := []byte{}
+
+	var lists [4][]uint8
+	prev := tokumaruRow{}
+
+	for t := 0; t < count; t++ {
+		newBlock := 1
+		if t > 0 {
+			var err error
+			newBlock, err = br.bit()
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if newBlock == 1 {
+			var err error
+			lists, err = tokumaruReadFollowers(br)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		tile := make([]byte, tokumaruTileSize)
+		for y := 0; y < 8; y++ {
+			repeat, err := br.bit()
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if repeat == 0 {
+				prev, err = tokumaruReadRow(br, lists)
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+
+			for x, c := range prev {
+				tile[y] |= (c & 0x01) << (7 - x)
+				tile[8+y] |= (c >> 1) << (7 - x)
+			}
+		}
+		out = append(out, tile...)
+	}
+
+	return out, 1 + br.consumed(), nil
+}
+
+func tokumaruReadFollowers(br *bitReader) ([4][]uint8, error) {
+	lists := [4][]uint8{}
+	for p := 3; p >= 0; p-- {
+		n, err := br.bits(2)
+		if err != nil {
+			return lists, err
+		}
+
+		used := [4]bool{}
+		used[p] = true
+		for i := 0; i < min(n, 2); i++ {
+			q, err := br.bits(2)
+			if err != nil {
+				return lists, err
+			}
+
+			if used[q] {
+				return lists, fmt.Errorf("color %d can't follow color %d twice, or itself", q, p)
+			}
+			used[q] = true
+			lists[p] = append(lists[p], uint8(q))
+		}
+
+		if n == 3 {
+			for q := range used {
+				if !used[q] {
+					lists[p] = append(lists[p], uint8(q))
+				}
+			}
+		}
+	}
+	return lists, nil
+}
+
+func tokumaruReadRow(br *bitReader, lists [4][]uint8) (tokumaruRow, error) {
+	row := tokumaruRow{}
+
+	first, err := br.bits(2)
+	if err != nil {
+		return row, err
+	}
+	row[0] = uint8(first)
+
+	for x := 1; x < 8; x++ {
+		prev := row[x-1]
+		row[x] = prev
+
+		list := lists[prev]
+		if len(list) == 0 {
+			continue
+		}
+
+		b, err := br.bit()
+		if err != nil {
+			return row, err
+		}
+		if b == 0 {
+			continue
+		}
+
+		for i, q := range list {
+			if i == len(list)-1 {
+				row[x] = q
+				break
+			}
+
+			b, err = br.bit()
+			if err != nil {
+				return row, err
+			}
+			if b == 0 {
+				row[x] = q
+				break
+			}
+		}
+	}
+
+	return row, nil
+}
+
+// bitWriter writes bits from the MSB of each byte down.
+type bitWriter struct {
+	data []byte
+	pos  int
+}
+
+func (bw *bitWriter) bit(b int) {
+	if bw.pos%8 == 0 {
+		bw.data = append(bw.data, 0)
+	}
+
+	if b != 0 {
+		bw.data[len(bw.data)-1] |= 0x80 >> (bw.pos % 8)
+	}
+	bw.pos++
+}
+
+func (bw *bitWriter) bool(b bool) {
+	if b {
+		bw.bit(1)
+	} else {
+		bw.bit(0)
+	}
+}
+
+// bits writes the low n bits of val, highest first.
+func (bw *bitWriter) bits(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		bw.bit((val >> i) & 0x01)
+	}
+}
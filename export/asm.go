@@ -0,0 +1,316 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the assembler, or C compiler, that output is written for.
+type Dialect int
+
+const (
+	AD_Ca65 Dialect = iota
+	AD_Asm6
+	AD_Nesasm
+	AD_WlaDx
+	AD_Bass
+	AD_Rgbds
+	AD_64tass
+	AD_C
+)
+
+func (d *Dialect) UnmarshalText(b []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "ca65":
+		*d = AD_Ca65
+	case "asm6", "asm6f":
+		*d = AD_Asm6
+	case "nesasm":
+		*d = AD_Nesasm
+	case "wla-dx", "wladx", "wla":
+		*d = AD_WlaDx
+	case "bass":
+		*d = AD_Bass
+	case "rgbds":
+		*d = AD_Rgbds
+	case "64tass":
+		*d = AD_64tass
+	case "c":
+		*d = AD_C
+	default:
+		return fmt.Errorf("Invalid assembler dialect: %q", string(b))
+	}
+
+	return nil
+}
+
+func (d Dialect) String() string {
+	switch d {
+	case AD_Ca65:
+		return "AD_Ca65"
+	case AD_Asm6:
+		return "AD_Asm6"
+	case AD_Nesasm:
+		return "AD_Nesasm"
+	case AD_WlaDx:
+		return "AD_WlaDx"
+	case AD_Bass:
+		return "AD_Bass"
+	case AD_Rgbds:
+		return "AD_Rgbds"
+	case AD_64tass:
+		return "AD_64tass"
+	case AD_C:
+		return "AD_C"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Ext is the extension of files written in the dialect.
+func (d Dialect) Ext() string {
+	if d == AD_C {
+		return ".c"
+	}
+	return ".inc"
+}
+
+// syntax is how a dialect writes each part of a table.
+type syntax struct {
+	bytes   string // directive for a line of bytes
+	words   string // directive for a 16-bit value
+	indent  string // before each directive
+	label   string // format for a label
+	comment string // format for a comment
+	hex     string // format for a byte in hex
+}
+
+var syntaxes = map[Dialect]syntax{
+	AD_Ca65:   {".byte", ".word", "", "%s:", "; %s", "$%02X"},
+	AD_Asm6:   {".db", ".dw", "", "%s:", "; %s", "$%02X"},
+	AD_Nesasm: {".db", ".dw", "\t", "%s:", "; %s", "$%02X"},
+	AD_WlaDx:  {".db", ".dw", "\t", "%s:", "; %s", "$%02X"},
+	AD_Bass:   {"db", "dw", "\t", "%s:", "// %s", "$%02X"},
+	AD_Rgbds:  {"db", "dw", "\t", "%s:", "; %s", "$%02X"},
+	AD_64tass: {".byte", ".word", "", "%s", "; %s", "$%02X"},
+	AD_C:      {"", "", "\t", "const unsigned char %s[] = {", "/* %s */", "0x%02X"},
+}
+
+// ParseOptions turns the --asm-out and --radix flags of a command into
+// Options, or returns nil if dialect is empty.  radix is hex or dec, and
+// defaults to dec.
+func ParseOptions(dialect, radix string) (*Options, error) {
+	if dialect == "" {
+		return nil, nil
+	}
+
+	opts := &Options{}
+	err := opts.Dialect.UnmarshalText([]byte(dialect))
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(radix) {
+	case "hex":
+		opts.Hex = true
+	case "dec", "":
+	default:
+		return nil, fmt.Errorf("Invalid --radix: %q", radix)
+	}
+
+	return opts, nil
+}
+
+// Options controls how tables are written.  The zero value writes ca65
+// .byte lines in decimal, one block to a line, without labels or comments.
+type Options struct {
+	Dialect Dialect
+	Hex     bool
+
+	// Values on each line.  Zero puts each block on its own line.
+	PerLine int
+
+	// Labels are only written when this is set, except in C where every
+	// array needs a name.  It is placed in front of the table's name.
+	LabelPrefix string
+
+	// Write a comment in front of each block, eg. with the index of a tile.
+	Comments bool
+}
+
+// Table is a named list of bytes made of blocks, like tiles or palettes.
+type Table struct {
+	Name   string
+	Blocks [][]byte
+
+	// Comment for the block at index i.  If nil the comment is the index.
+	Comment func(i int) string
+}
+
+// Label returns the name a table is written with.
+func (o Options) Label(name string) string {
+	label := o.LabelPrefix + name
+	if o.Dialect == AD_C && label == "" {
+		label = "data"
+	}
+	return label
+}
+
+func (o Options) value(b byte) string {
+	if o.Hex {
+		return fmt.Sprintf(syntaxes[o.Dialect].hex, b)
+	}
+	return strconv.Itoa(int(b))
+}
+
+// WriteTable writes t in the options' dialect.
+func (o Options) WriteTable(w io.Writer, t Table) error {
+	syn, ok := syntaxes[o.Dialect]
+	if !ok {
+		return fmt.Errorf("unknown dialect: %s", o.Dialect)
+	}
+
+	lines := []string{}
+
+	label := o.Label(t.Name)
+	if o.LabelPrefix != "" || o.Dialect == AD_C {
+		lines = append(lines, fmt.Sprintf(syn.label, label))
+	}
+
+	// Values that haven't been written out yet.
+	vals := []string{}
+	flush := func() {
+		if len(vals) == 0 {
+			return
+		}
+
+		if o.Dialect == AD_C {
+			lines = append(lines, syn.indent+strings.Join(vals, ", ")+",")
+		} else {
+			lines = append(lines, syn.indent+syn.bytes+" "+strings.Join(vals, ", "))
+		}
+		vals = vals[:0]
+	}
+
+	for i, block := range t.Blocks {
+		if o.Comments {
+			flush()
+
+			comment := strconv.Itoa(i)
+			if t.Comment != nil {
+				comment = t.Comment(i)
+			}
+			lines = append(lines, syn.indent+fmt.Sprintf(syn.comment, comment))
+		}
+
+		for _, b := range block {
+			vals = append(vals, o.value(b))
+			if o.PerLine > 0 && len(vals) == o.PerLine {
+				flush()
+			}
+		}
+
+		if o.PerLine <= 0 {
+			flush()
+		}
+	}
+	flush()
+
+	if o.Dialect == AD_C {
+		lines = append(lines, "};")
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteWord writes a single 16-bit value called name, eg. the size of a
+// table.
+func (o Options) WriteWord(w io.Writer, name string, val uint16) error {
+	syn, ok := syntaxes[o.Dialect]
+	if !ok {
+		return fmt.Errorf("unknown dialect: %s", o.Dialect)
+	}
+
+	label := o.Label(name)
+	if o.Dialect == AD_C {
+		_, err := fmt.Fprintf(w, "const unsigned short %s = %d;\n", label, val)
+		return err
+	}
+
+	lines := []string{}
+	if o.LabelPrefix != "" {
+		lines = append(lines, fmt.Sprintf(syn.label, label))
+	}
+
+	value := strconv.Itoa(int(val))
+	if o.Hex {
+		value = fmt.Sprintf(strings.Replace(syn.hex, "02", "04", 1), val)
+	}
+	lines = append(lines, syn.indent+syn.words+" "+value)
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteFile writes t to filename as a C array if the extension is .c or .h,
+// as assembly if it is .inc, .asm, or .s, and as binary otherwise.  Assembly
+// uses the dialect in opts, or ca65 if opts is nil or is for C.
+func WriteFile(filename string, t Table, opts *Options) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	o := Options{Hex: true}
+	if opts != nil {
+		o = *opts
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".c", ".h":
+		o.Dialect = AD_C
+		return o.WriteTable(file, t)
+
+	case ".inc", ".asm", ".s":
+		if o.Dialect == AD_C {
+			o.Dialect = AD_Ca65
+		}
+		return o.WriteTable(file, t)
+	}
+
+	_, err = file.Write(bytes.Join(t.Blocks, []byte{}))
+	return err
+}
+
+// Blocks splits data into blocks of size bytes.  The last block may be
+// shorter.
+func Blocks(data []byte, size int) [][]byte {
+	if size <= 0 {
+		return [][]byte{data}
+	}
+
+	blocks := [][]byte{}
+	for i := 0; i < len(data); i += size {
+		blocks = append(blocks, data[i:min(i+size, len(data))])
+	}
+	return blocks
+}
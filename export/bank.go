@@ -0,0 +1,182 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Asset is a named piece of data to place in banks, eg. a CHR table or a
+// nametable.
+type Asset struct {
+	Name string
+	Data []byte
+
+	// An asset larger than a bank is split into pieces that are a multiple
+	// of this many bytes, eg. the size of a tile.  If zero the asset must
+	// fit in a single bank.
+	Align int
+}
+
+// Piece is the part of an asset stored in one bank.
+type Piece struct {
+	Asset  string
+	Bank   int
+	Offset int // from the start of the bank
+	Start  int // from the start of the asset
+	Size   int
+}
+
+// Banks holds assets split into fixed-size banks.
+type Banks struct {
+	Size  int
+	Data  [][]byte
+	Index []Piece
+}
+
+// ParseSize parses a bank size in bytes, with an optional K suffix, eg.
+// "8192", "8k", or "8K".
+func ParseSize(s string) (int, error) {
+	num := strings.ToLower(strings.TrimSpace(s))
+	mult := 1
+	if strings.HasSuffix(num, "k") {
+		num = strings.TrimSuffix(num, "k")
+		mult = 1024
+	}
+
+	val, err := strconv.ParseUint(num, 0, 32)
+	if err != nil || val == 0 {
+		return 0, fmt.Errorf("Invalid bank size: %q", s)
+	}
+
+	return int(val) * mult, nil
+}
+
+// SplitBanks places assets into banks of size bytes, in order.  An asset
+// that doesn't fit in the rest of the current bank starts a new one.  Assets
+// larger than a bank are split across banks if they have an Align, with a
+// warning, otherwise an error is returned.
+func SplitBanks(size int, assets []Asset) (*Banks, []string, error) {
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("Invalid bank size: %d", size)
+	}
+
+	b := &Banks{Size: size, Data: [][]byte{{}}}
+	warnings := []string{}
+
+	for _, asset := range assets {
+		cur := len(b.Data) - 1
+
+		if len(asset.Data) > size {
+			if asset.Align <= 0 {
+				return nil, nil, fmt.Errorf("%s is %d bytes and doesn't fit in a %d byte bank", asset.Name, len(asset.Data), size)
+			}
+
+			if asset.Align > size {
+				return nil, nil, fmt.Errorf("%s can't be split into %d byte pieces in a %d byte bank", asset.Name, asset.Align, size)
+			}
+
+		} else if len(b.Data[cur])+len(asset.Data) > size {
+			b.Data = append(b.Data, []byte{})
+		}
+
+		first := len(b.Data) - 1
+		for start := 0; ; {
+			cur = len(b.Data) - 1
+
+			// Only assets larger than a bank are cut short here.
+			n := min(len(asset.Data)-start, size-len(b.Data[cur]))
+			if n < len(asset.Data)-start {
+				n -= n % asset.Align
+			}
+
+			if n > 0 || len(asset.Data) == 0 {
+				b.Index = append(b.Index, Piece{
+					Asset:  asset.Name,
+					Bank:   cur,
+					Offset: len(b.Data[cur]),
+					Start:  start,
+					Size:   n,
+				})
+				b.Data[cur] = append(b.Data[cur], asset.Data[start:start+n]...)
+			}
+
+			start += n
+			if start >= len(asset.Data) {
+				break
+			}
+			b.Data = append(b.Data, []byte{})
+		}
+
+		if last := len(b.Data) - 1; last != first {
+			warnings = append(warnings, fmt.Sprintf("%s is %d bytes and was split across banks %d-%d", asset.Name, len(asset.Data), first, last))
+		}
+	}
+
+	return b, warnings, nil
+}
+
+// Padded returns bank i filled out to the full bank size with fill.
+func (b *Banks) Padded(i int, fill byte) []byte {
+	data := make([]byte, b.Size)
+	n := copy(data, b.Data[i])
+	for j := n; j < len(data); j++ {
+		data[j] = fill
+	}
+	return data
+}
+
+// Pieces returns the pieces stored in bank i.
+func (b *Banks) Pieces(i int) []Piece {
+	pieces := []Piece{}
+	for _, p := range b.Index {
+		if p.Bank == i {
+			pieces = append(pieces, p)
+		}
+	}
+	return pieces
+}
+
+// IndexTable returns a table with a row for each piece: its bank, then its
+// offset in the bank and size as 16-bit little endian words.
+func (b *Banks) IndexTable(name string) Table {
+	blocks := [][]byte{}
+	for _, p := range b.Index {
+		blocks = append(blocks, []byte{
+			byte(p.Bank),
+			byte(p.Offset), byte(p.Offset >> 8),
+			byte(p.Size), byte(p.Size >> 8),
+		})
+	}
+
+	return Table{
+		Name:   name,
+		Blocks: blocks,
+		Comment: func(i int) string {
+			p := b.Index[i]
+			return fmt.Sprintf("%s $%04X-$%04X", p.Asset, p.Start, p.Start+p.Size-1)
+		},
+	}
+}
+
+// WriteBank writes bank i as a table called name, one block per align bytes
+// of each piece.  An align of zero puts each piece in a single block.
+func (o Options) WriteBank(w io.Writer, name string, b *Banks, i, align int) error {
+	blocks := [][]byte{}
+	comments := []string{}
+	for _, p := range b.Pieces(i) {
+		for j, block := range Blocks(b.Data[i][p.Offset:p.Offset+p.Size], align) {
+			blocks = append(blocks, block)
+			comments = append(comments, fmt.Sprintf("%s %d", p.Asset, p.Start/max(align, 1)+j))
+		}
+	}
+
+	return o.WriteTable(w, Table{
+		Name:   name,
+		Blocks: blocks,
+		Comment: func(i int) string {
+			return comments[i]
+		},
+	})
+}
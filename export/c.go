@@ -0,0 +1,212 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Toolchain picks the pragmas used to place arrays in a segment or bank.
+type Toolchain int
+
+const (
+	TC_None Toolchain = iota
+	TC_Cc65
+	TC_Sdcc
+	TC_Gbdk
+)
+
+func (tc *Toolchain) UnmarshalText(b []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "", "none":
+		*tc = TC_None
+	case "cc65":
+		*tc = TC_Cc65
+	case "sdcc":
+		*tc = TC_Sdcc
+	case "gbdk":
+		*tc = TC_Gbdk
+	default:
+		return fmt.Errorf("Invalid toolchain: %q", string(b))
+	}
+
+	return nil
+}
+
+func (tc Toolchain) String() string {
+	switch tc {
+	case TC_None:
+		return "TC_None"
+	case TC_Cc65:
+		return "TC_Cc65"
+	case TC_Sdcc:
+		return "TC_Sdcc"
+	case TC_Gbdk:
+		return "TC_Gbdk"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Define is a size macro written to the header, eg. a tile count.  Its name
+// is added to the end of the array's name.
+type Define struct {
+	Name  string
+	Value int
+}
+
+// CArray is a table along with the size macros that go in its header.  A
+// _SIZE macro with the number of bytes is always written.
+type CArray struct {
+	Table
+	Defines []Define
+}
+
+// Size returns the number of bytes in the array.
+func (a CArray) Size() int {
+	size := 0
+	for _, b := range a.Blocks {
+		size += len(b)
+	}
+	return size
+}
+
+// COptions controls how C headers and sources are written.  The embedded
+// Options' Dialect is ignored.
+type COptions struct {
+	Options
+
+	Toolchain Toolchain
+
+	// Segment the arrays are placed in for cc65 and SDCC.  Empty to leave
+	// them in the default segment.
+	Segment string
+
+	// ROM bank for GBDK, or -1 to leave the arrays unbanked.
+	Bank int
+}
+
+var notIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Identifier turns s into a valid C identifier, eg. a filename into a guard
+// or array name.
+func Identifier(s string) string {
+	s = notIdent.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// macro is the uppercase form of a label used for its size macros.
+func macro(label string) string {
+	return strings.ToUpper(Identifier(label))
+}
+
+// WriteHeader writes the extern declarations and size macros for arrays,
+// wrapped in an include guard.
+func (o COptions) WriteHeader(w io.Writer, guard string, arrays []CArray) error {
+	o.Dialect = AD_C
+	guard = macro(guard)
+	lines := []string{
+		"#ifndef " + guard,
+		"#define " + guard,
+		"",
+	}
+
+	if o.Toolchain == TC_Gbdk && o.Bank >= 0 {
+		lines = append(lines, "#include <gbdk/platform.h>", "")
+	}
+
+	for _, a := range arrays {
+		label := Identifier(o.Label(a.Name))
+		lines = append(lines, fmt.Sprintf("#define %s_SIZE %d", macro(label), a.Size()))
+		for _, d := range a.Defines {
+			lines = append(lines, fmt.Sprintf("#define %s_%s %d", macro(label), macro(d.Name), d.Value))
+		}
+
+		lines = append(lines, fmt.Sprintf("extern const unsigned char %s[%d];", label, a.Size()))
+		if o.Toolchain == TC_Gbdk && o.Bank >= 0 {
+			lines = append(lines, fmt.Sprintf("BANKREF_EXTERN(%s)", label))
+		}
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "#endif")
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteSource writes the arrays themselves.  header is included at the top
+// of the file if it isn't empty.
+func (o COptions) WriteSource(w io.Writer, header string, arrays []CArray) error {
+	o.Dialect = AD_C
+	lines := []string{}
+
+	// GBDK wants the bank before anything else.
+	if o.Toolchain == TC_Gbdk && o.Bank >= 0 {
+		lines = append(lines, fmt.Sprintf("#pragma bank %d", o.Bank), "")
+	}
+
+	if header != "" {
+		lines = append(lines, fmt.Sprintf("#include %q", header), "")
+	}
+
+	if o.Segment != "" {
+		switch o.Toolchain {
+		case TC_Cc65:
+			lines = append(lines, fmt.Sprintf("#pragma rodata-name (push, %q)", o.Segment), "")
+		case TC_Sdcc:
+			lines = append(lines, fmt.Sprintf("#pragma constseg %s", o.Segment), "")
+		}
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The prefix is already part of each array's identifier.
+	opts := o.Options
+	opts.LabelPrefix = ""
+
+	for _, a := range arrays {
+		a.Name = Identifier(o.Label(a.Name))
+
+		if o.Toolchain == TC_Gbdk && o.Bank >= 0 {
+			_, err := fmt.Fprintf(w, "BANKREF(%s)\n", a.Name)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := opts.WriteTable(w, a.Table)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.Segment != "" && o.Toolchain == TC_Cc65 {
+		_, err := fmt.Fprintln(w, "#pragma rodata-name (pop)")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -5,10 +5,10 @@ import (
 	"io"
 	"fmt"
 	"image/color"
-	"strconv"
-	"strings"
 	"bytes"
 	"math"
+
+	"github.com/zorchenhimer/go-retroimg/export"
 )
 
 var _ image.PalettedImage = &TiledImage{}
@@ -24,6 +24,9 @@ type TiledImage struct {
 	Palette  color.Palette
 	BitDepth BitDepth
 
+	// Order of the bit planes in the binary output.  Defaults to CF_Nes.
+	Format ChrFormat
+
 	bounds image.Rectangle
 }
 
@@ -85,7 +88,45 @@ func NewTiledImage(r image.Rectangle, cs CharSize, depth BitDepth, pal color.Pal
 	}, nil
 }
 
+// ConvertOptions change how the colors of a source image are matched to
+// the palette in NewTiledImageFromImageOptions().
+type ConvertOptions struct {
+	// Snap the palette and every pixel of a non-paletted image to the colors
+	// the target hardware can display before matching them.  Usually a
+	// palette.ColorSpace.
+	ColorModel color.Model
+
+	// Pixels with an alpha value below this are set to color index 0
+	// instead of being matched by color.  Zero disables this.
+	AlphaThreshold uint8
+}
+
+func (opts *ConvertOptions) transparent(c color.Color) bool {
+	if opts.AlphaThreshold == 0 {
+		return false
+	}
+
+	_, _, _, a := c.RGBA()
+	return uint8(a>>8) < opts.AlphaThreshold
+}
+
 func NewTiledImageFromImage(cs CharSize, depth BitDepth, pal color.Palette, img image.Image) (*TiledImage, error) {
+	return NewTiledImageFromImageOptions(cs, depth, pal, img, nil)
+}
+
+func NewTiledImageFromImageOptions(cs CharSize, depth BitDepth, pal color.Palette, img image.Image, opts *ConvertOptions) (*TiledImage, error) {
+	if opts == nil {
+		opts = &ConvertOptions{}
+	}
+
+	if opts.ColorModel != nil {
+		snapped := color.Palette{}
+		for _, c := range pal {
+			snapped = append(snapped, opts.ColorModel.Convert(c))
+		}
+		pal = snapped
+	}
+
 	ti, err := NewTiledImage(img.Bounds(), cs, depth, pal)
 	if err != nil {
 		return nil, err
@@ -103,7 +144,9 @@ func NewTiledImageFromImage(cs CharSize, depth BitDepth, pal color.Palette, img
 		palimg := img.(*image.Paletted)
 		for y := 0; y < ti.bounds.Max.Y; y++ {
 			for x := 0; x < ti.bounds.Max.X; x++ {
-				if depth == BD_8bpp {
+				if opts.transparent(palimg.At(x, y)) {
+					ti.SetColorIndex(x, y, 0)
+				} else if depth == BD_8bpp {
 					ti.SetColorIndex(x, y, palimg.ColorIndexAt(x, y))
 				} else {
 					idx := palimg.ColorIndexAt(x, y) % bppMod
@@ -118,7 +161,16 @@ func NewTiledImageFromImage(cs CharSize, depth BitDepth, pal color.Palette, img
 		fmt.Println("[RGB]")
 		for y := 0; y < ti.bounds.Max.Y; y++ {
 			for x := 0; x < ti.bounds.Max.X; x++ {
-				ti.Set(x, y, img.At(x, y))
+				c := img.At(x, y)
+				if opts.transparent(c) {
+					ti.SetColorIndex(x, y, 0)
+					continue
+				}
+
+				if opts.ColorModel != nil {
+					c = opts.ColorModel.Convert(c)
+				}
+				ti.Set(x, y, c)
 			}
 		}
 	}
@@ -213,25 +265,83 @@ func (ti *TiledImage) ColorModel() color.Model {
 func (ti *TiledImage) binary() [][]byte {
 	ret := [][]byte{}
 	for _, tile := range ti.Tiles {
-		ret = append(ret, tile.binary())
+		ret = append(ret, ti.Format.Encode(tile))
 	}
 	return ret
 }
 
 func (ti *TiledImage) WriteAsm(w io.Writer) error {
+	return ti.WriteAsmOptions(w, "", export.Options{})
+}
+
+// WriteAsmOptions writes the tiles as a table called name, one block per
+// tile.
+func (ti *TiledImage) WriteAsmOptions(w io.Writer, name string, opts export.Options) error {
+	return opts.WriteTable(w, export.Table{
+		Name:   name,
+		Blocks: ti.binary(),
+		Comment: func(i int) string {
+			return fmt.Sprintf("tile %d", i)
+		},
+	})
+}
+
+// CArray returns the tiles as a C array called name, with macros for the
+// tile count and the image's width and height in characters.
+func (ti *TiledImage) CArray(name string) export.CArray {
+	width, height := ti.CharacterSize.XY()
+	return export.CArray{
+		Table: export.Table{
+			Name:   name,
+			Blocks: ti.binary(),
+			Comment: func(i int) string {
+				return fmt.Sprintf("tile %d", i)
+			},
+		},
+		Defines: []export.Define{
+			{Name: "TILE_COUNT", Value: len(ti.Tiles)},
+			{Name: "WIDTH", Value: ti.bounds.Dx()/width},
+			{Name: "HEIGHT", Value: ti.bounds.Dy()/height},
+		},
+	}
+}
+
+// Asset returns the image's tiles as an asset called name, which can be
+// split across banks between any two tiles.
+func (ti *TiledImage) Asset(name string) export.Asset {
 	tiles := ti.binary()
-	for _, tile := range tiles {
-		vals := []string{}
-		for _, b := range tile {
-			vals = append(vals, strconv.Itoa(int(b)))
-		}
+	asset := export.Asset{Name: name, Data: bytes.Join(tiles, []byte{})}
+	if len(tiles) > 0 {
+		asset.Align = len(tiles[0])
+	}
+	return asset
+}
 
-		_, err := fmt.Fprintf(w, ".byte %s\n", strings.Join(vals, ", "))
-		if err != nil {
-			return err
-		}
+// NametableCArray returns a nametable's tile IDs as a C array called name,
+// with macros for its width and height in tiles.  Uncompressed IDs are split
+// into rows.  Compressed IDs don't line up with rows, so they are written in
+// plain blocks of 16 bytes.
+func NametableCArray(name string, ids []byte, width, height int, compressed bool) export.CArray {
+	table := export.Table{
+		Name:   name,
+		Blocks: export.Blocks(ids, width),
+		Comment: func(i int) string {
+			return fmt.Sprintf("row %d", i)
+		},
+	}
+
+	if compressed {
+		table.Blocks = export.Blocks(ids, 16)
+		table.Comment = nil
+	}
+
+	return export.CArray{
+		Table: table,
+		Defines: []export.Define{
+			{Name: "WIDTH", Value: width},
+			{Name: "HEIGHT", Value: height},
+		},
 	}
-	return nil
 }
 
 func (ti *TiledImage) WriteBin(w io.Writer) error {
@@ -240,28 +350,27 @@ func (ti *TiledImage) WriteBin(w io.Writer) error {
 	return err
 }
 
+// UniqueTiles returns the image's tiles without duplicates, in the order
+// they first appear.  TileIds is set to the index in the returned list of
+// each of the image's tiles.
 func (ti *TiledImage) UniqueTiles() TileList {
 	ti.TileIds = []int{}
 	unique := TileList{}
-	for a, tile := range ti.Tiles {
-		found := false
-		for _, u := range ti.TileIds {
-			if tile.IsIdentical(ti.Tiles[u]) {
-				found = true
-				//fmt.Printf("%d == %d\n", a, u)
-				ti.TileIds = append(ti.TileIds, u)
+	for _, tile := range ti.Tiles {
+		id := -1
+		for u, utile := range unique {
+			if tile.IsIdentical(utile) {
+				id = u
 				break
 			}
 		}
 
-		if !found {
-			ti.TileIds = append(ti.TileIds, a)
-			unique = append(unique, ti.Tiles[a])
+		if id < 0 {
+			id = len(unique)
+			unique = append(unique, tile)
 		}
+		ti.TileIds = append(ti.TileIds, id)
 	}
 
-	//for _, id := range ti.TileIds {
-	//	unique = append(unique, ti.Tiles[id])
-	//}
 	return unique
 }
@@ -0,0 +1,467 @@
+package retroimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// SpriteLayout is how a system finds the tiles of a sprite larger than 8x8
+// from its first tile.
+type SpriteLayout int
+
+const (
+	// 8x16 sprites use two tiles in a row starting at an even index.
+	SL_Nes SpriteLayout = iota
+
+	// Sprites are a block of tiles in VRAM that is 16 tiles wide.
+	SL_Snes
+)
+
+func (sl *SpriteLayout) UnmarshalText(b []byte) error {
+	switch strings.ToLower(string(b)) {
+	case "nes":
+		*sl = SL_Nes
+	case "snes":
+		*sl = SL_Snes
+	default:
+		return fmt.Errorf("Invalid sprite layout: %q", string(b))
+	}
+
+	return nil
+}
+
+func (sl SpriteLayout) String() string {
+	switch sl {
+	case SL_Nes:
+		return "SL_Nes"
+	case SL_Snes:
+		return "SL_Snes"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// SpriteSizes is the pair of hardware sprite sizes in pixels that can be
+// used at once, eg. one of the SNES OBSEL settings.  Both are the same when
+// there is only one size, like on the NES.
+type SpriteSizes struct {
+	Small image.Point
+	Large image.Point
+}
+
+// ParseSpriteSizes parses a single size, like "8x8" or "8x16", or a small
+// and large pair, like "8/16" or "16x32/32x64".
+func ParseSpriteSizes(s string) (SpriteSizes, error) {
+	parse := func(size string) (image.Point, error) {
+		w, h, found := strings.Cut(size, "x")
+		if !found {
+			h = w
+		}
+
+		width, err := strconv.Atoi(w)
+		if err != nil {
+			return image.Point{}, fmt.Errorf("Invalid sprite size: %q", s)
+		}
+
+		height, err := strconv.Atoi(h)
+		if err != nil {
+			return image.Point{}, fmt.Errorf("Invalid sprite size: %q", s)
+		}
+
+		if width <= 0 || height <= 0 || width%8 != 0 || height%8 != 0 {
+			return image.Point{}, fmt.Errorf("Sprite sizes must be multiples of 8: %q", s)
+		}
+
+		return image.Pt(width, height), nil
+	}
+
+	small, large, pair := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "/")
+
+	sizes := SpriteSizes{}
+	var err error
+	sizes.Small, err = parse(small)
+	if err != nil {
+		return sizes, err
+	}
+
+	sizes.Large = sizes.Small
+	if pair {
+		sizes.Large, err = parse(large)
+		if err != nil {
+			return sizes, err
+		}
+	}
+
+	return sizes, nil
+}
+
+// Sprite is one hardware sprite of a metasprite.
+type Sprite struct {
+	// Offset from the metasprite's origin.
+	X, Y int
+
+	// Index of the sprite's first tile.
+	Tile int
+
+	FlipH, FlipV bool
+
+	// Uses the large size of the pair.
+	Large bool
+}
+
+// MetaSprite is a group of hardware sprites drawn together, eg. one frame
+// of an animation.
+type MetaSprite struct {
+	Sprites []Sprite
+}
+
+// SpriteSheet covers the opaque pixels of images with hardware sprites, and
+// collects the unique tiles they use.
+type SpriteSheet struct {
+	Layout SpriteLayout
+	Sizes  SpriteSizes
+
+	Depth   BitDepth
+	Palette color.Palette
+
+	// Tiles by index.  Unused indexes are nil.
+	Tiles []*Tile
+
+	// First tile of each unique sprite, by the hashes of its tiles.
+	graphics map[string]int
+}
+
+func NewSpriteSheet(layout SpriteLayout, sizes SpriteSizes, depth BitDepth, pal color.Palette) *SpriteSheet {
+	return &SpriteSheet{
+		Layout:   layout,
+		Sizes:    sizes,
+		Depth:    depth,
+		Palette:  pal,
+		graphics: map[string]int{},
+	}
+}
+
+// TileList returns every tile in index order, with blank tiles in the unused
+// indexes.
+func (ss *SpriteSheet) TileList() TileList {
+	tl := TileList{}
+	for _, tile := range ss.Tiles {
+		if tile == nil {
+			tile = NewTile(ss.Depth, ss.Palette)
+		}
+		tl = append(tl, tile)
+	}
+	return tl
+}
+
+// MetaSprite covers the pixels of img inside r that aren't color index 0
+// with as few sprites as it can.  Sprite offsets are relative to origin.
+func (ss *SpriteSheet) MetaSprite(img image.PalettedImage, r image.Rectangle, origin image.Point) (*MetaSprite, error) {
+	opaque := func(x, y int) bool {
+		p := image.Pt(x, y)
+		return p.In(r) && p.In(img.Bounds()) && img.ColorIndexAt(x, y) != 0
+	}
+
+	rects := coverSprites(opaque, r, ss.Sizes.Small)
+	if ss.Sizes.Large != ss.Sizes.Small {
+		mixed := ss.coverMixed(opaque, r)
+		if len(mixed) < len(rects) {
+			rects = mixed
+		}
+	}
+
+	ms := &MetaSprite{}
+	for _, rect := range rects {
+		tile, flipH, flipV, err := ss.addGraphic(img, r, rect)
+		if err != nil {
+			return nil, err
+		}
+
+		ms.Sprites = append(ms.Sprites, Sprite{
+			X:     rect.Min.X - origin.X,
+			Y:     rect.Min.Y - origin.Y,
+			Tile:  tile,
+			FlipH: flipH,
+			FlipV: flipV,
+			Large: rect.Size() != ss.Sizes.Small,
+		})
+	}
+
+	return ms, nil
+}
+
+// coverSprites covers the opaque pixels in r with sprites of one size.  Each
+// band of rows starts at the topmost uncovered pixel, and is covered left to
+// right.
+func coverSprites(opaque func(x, y int) bool, r image.Rectangle, size image.Point) []image.Rectangle {
+	covered := map[image.Point]bool{}
+	rects := []image.Rectangle{}
+
+	pending := func(x, y int) bool {
+		return opaque(x, y) && !covered[image.Pt(x, y)]
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !pending(x, y) {
+				continue
+			}
+
+			for bx := r.Min.X; bx < r.Max.X; bx++ {
+				found := false
+				for by := y; by < y+size.Y; by++ {
+					if pending(bx, by) {
+						found = true
+						break
+					}
+				}
+
+				if !found {
+					continue
+				}
+
+				rect := image.Rectangle{Min: image.Pt(bx, y), Max: image.Pt(bx+size.X, y+size.Y)}
+				for py := rect.Min.Y; py < rect.Max.Y; py++ {
+					for px := rect.Min.X; px < rect.Max.X; px++ {
+						covered[image.Pt(px, py)] = true
+					}
+				}
+				rects = append(rects, rect)
+				bx = rect.Max.X - 1
+			}
+			break
+		}
+	}
+
+	return rects
+}
+
+// coverMixed covers with large sprites, then swaps each for a small one
+// where a small sprite covers everything the large one did.
+func (ss *SpriteSheet) coverMixed(opaque func(x, y int) bool, r image.Rectangle) []image.Rectangle {
+	large := coverSprites(opaque, r, ss.Sizes.Large)
+	rects := []image.Rectangle{}
+
+	for i, rect := range large {
+		mine := func(x, y int) bool {
+			p := image.Pt(x, y)
+			if !p.In(rect) || !opaque(x, y) {
+				return false
+			}
+
+			for _, prev := range large[:i] {
+				if p.In(prev) {
+					return false
+				}
+			}
+			return true
+		}
+
+		small := coverSprites(mine, rect, ss.Sizes.Small)
+		if len(small) == 1 {
+			rects = append(rects, small[0])
+		} else {
+			rects = append(rects, rect)
+		}
+	}
+
+	return rects
+}
+
+// spriteTiles reads the tiles under rect, row by row.  Pixels outside of r
+// are left transparent.
+func (ss *SpriteSheet) spriteTiles(img image.PalettedImage, r, rect image.Rectangle) [][]*Tile {
+	rows := [][]*Tile{}
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += 8 {
+		row := []*Tile{}
+		for tx := rect.Min.X; tx < rect.Max.X; tx += 8 {
+			tile := NewTile(ss.Depth, ss.Palette)
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					p := image.Pt(tx+x, ty+y)
+					if p.In(r) && p.In(img.Bounds()) {
+						tile.SetColorIndex(x, y, img.ColorIndexAt(p.X, p.Y))
+					}
+				}
+			}
+			row = append(row, tile)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// flipTiles flips a sprite's tiles as a whole.
+func flipTiles(rows [][]*Tile, h, v bool) [][]*Tile {
+	flipped := [][]*Tile{}
+	for _, row := range rows {
+		frow := []*Tile{}
+		for _, tile := range row {
+			ft := NewTile(tile.Depth, tile.Palette)
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					sx, sy := x, y
+					if h {
+						sx = 7 - x
+					}
+					if v {
+						sy = 7 - y
+					}
+					ft.SetColorIndex(x, y, tile.ColorIndexAt(sx, sy))
+				}
+			}
+
+			if h {
+				frow = append([]*Tile{ft}, frow...)
+			} else {
+				frow = append(frow, ft)
+			}
+		}
+
+		if v {
+			flipped = append([][]*Tile{frow}, flipped...)
+		} else {
+			flipped = append(flipped, frow)
+		}
+	}
+	return flipped
+}
+
+func tilesKey(rows [][]*Tile) string {
+	key := []string{}
+	for _, row := range rows {
+		for _, tile := range row {
+			key = append(key, tile.Hash())
+		}
+		key = append(key, "/")
+	}
+	return strings.Join(key, "")
+}
+
+// addGraphic returns the first tile of the sprite under rect, reusing a
+// sprite that matches it or a flipped copy of it, or adding it if there
+// isn't one.
+func (ss *SpriteSheet) addGraphic(img image.PalettedImage, r, rect image.Rectangle) (int, bool, bool, error) {
+	rows := ss.spriteTiles(img, r, rect)
+
+	for _, flip := range [][2]bool{{false, false}, {true, false}, {false, true}, {true, true}} {
+		if tile, ok := ss.graphics[tilesKey(flipTiles(rows, flip[0], flip[1]))]; ok {
+			return tile, flip[0], flip[1], nil
+		}
+	}
+
+	offsets, align, width := ss.layout(len(rows[0]), len(rows))
+	base := -1
+	for b := 0; base < 0; b += align {
+		if ss.Layout == SL_Snes && b%16+width > 16 {
+			continue
+		}
+
+		free := true
+		for _, off := range offsets {
+			if b+off < len(ss.Tiles) && ss.Tiles[b+off] != nil {
+				free = false
+				break
+			}
+		}
+
+		if free {
+			base = b
+		}
+	}
+
+	i := 0
+	for _, row := range rows {
+		for _, tile := range row {
+			idx := base + offsets[i]
+			for len(ss.Tiles) <= idx {
+				ss.Tiles = append(ss.Tiles, nil)
+			}
+			ss.Tiles[idx] = tile
+			i++
+		}
+	}
+
+	// 8x8 NES sprites can only use one pattern table.
+	limit := 512
+	if ss.Layout == SL_Nes && ss.Sizes.Small.Y == 8 {
+		limit = 256
+	}
+
+	if len(ss.Tiles) > limit {
+		return 0, false, false, fmt.Errorf("Too many tiles: %d; max: %d", len(ss.Tiles), limit)
+	}
+
+	ss.graphics[tilesKey(rows)] = base
+	return base, false, false, nil
+}
+
+// layout returns the offset of each tile of a sprite from its first tile,
+// row by row, along with how the first tile is aligned and how many tiles
+// wide the sprite is in VRAM.
+func (ss *SpriteSheet) layout(cols, rows int) ([]int, int, int) {
+	offsets := []int{}
+	if ss.Layout == SL_Nes {
+		for i := 0; i < cols*rows; i++ {
+			offsets = append(offsets, i)
+		}
+		return offsets, cols * rows, cols * rows
+	}
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			offsets = append(offsets, y*16+x)
+		}
+	}
+	return offsets, 1, cols
+}
+
+// NesEntry returns the sprite as x offset, y offset, tile, and attributes,
+// the way neslib and the OAM store it.  Tiles in the second pattern table of
+// 8x16 sprites are selected with bit 0.
+func (s Sprite) NesEntry(palette int) ([]byte, error) {
+	if s.X < -128 || s.X > 127 || s.Y < -128 || s.Y > 127 {
+		return nil, fmt.Errorf("Sprite offset %d,%d is out of range", s.X, s.Y)
+	}
+
+	tile := s.Tile
+	if tile > 0xFF {
+		tile = (tile & 0xFF) | 0x01
+	}
+
+	attr := byte(palette & 0x03)
+	if s.FlipH {
+		attr |= 0x40
+	}
+	if s.FlipV {
+		attr |= 0x80
+	}
+
+	return []byte{byte(s.X), byte(s.Y), byte(tile), attr}, nil
+}
+
+// SnesEntry returns the sprite as x offset, y offset, the lower 8 bits of the
+// tile, the attributes (vhoopppN), and 1 if it uses the large size.
+func (s Sprite) SnesEntry(palette, priority int) ([]byte, error) {
+	if s.X < -128 || s.X > 127 || s.Y < -128 || s.Y > 127 {
+		return nil, fmt.Errorf("Sprite offset %d,%d is out of range", s.X, s.Y)
+	}
+
+	attr := byte(priority&0x03)<<4 | byte(palette&0x07)<<1 | byte(s.Tile>>8)&0x01
+	if s.FlipH {
+		attr |= 0x40
+	}
+	if s.FlipV {
+		attr |= 0x80
+	}
+
+	size := byte(0)
+	if s.Large {
+		size = 1
+	}
+
+	return []byte{byte(s.X), byte(s.Y), byte(s.Tile), attr, size}, nil
+}
@@ -1,6 +1,7 @@
 package retroimg
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 )
@@ -100,3 +101,78 @@ func (mt *MetaTile) Bounds() image.Rectangle {
 	)
 }
 
+
+// ImageStride is the number of metatiles in each row of the image of a
+// segment that holds count metatiles.  Images of segments shorter than a
+// row are only as wide as the segment.
+func ImageStride(stride, count int) int {
+	return min(stride, count)
+}
+
+// TilesFromMetaImage undoes the arrangement of a MetaImage.  The image is
+// cut into metatiles of width x height tiles, stride metatiles per row, and
+// the tiles of each are put back in their original order using tileOrder.
+// Every pixel must exactly match a color in pal.  At most count metatiles
+// are read and an error is returned if the image holds more than that.  The
+// image must be as wide as ImageStride(stride, count) metatiles.
+func TilesFromMetaImage(img image.Image, depth BitDepth, pal color.Palette, width, height int, tileOrder []int, stride, count int) ([]*Tile, error) {
+	if len(tileOrder) != width*height {
+		return nil, fmt.Errorf("TileOrder has %d entries; expected %d", len(tileOrder), width*height)
+	}
+
+	stride = ImageStride(stride, count)
+
+	mtWidth, mtHeight := width*8, height*8
+	bounds := img.Bounds()
+	if bounds.Dx() != stride*mtWidth {
+		return nil, fmt.Errorf("image is %dpx wide; expected %dpx", bounds.Dx(), stride*mtWidth)
+	}
+
+	if bounds.Dy()%mtHeight != 0 {
+		return nil, fmt.Errorf("image height of %dpx is not a multiple of %dpx", bounds.Dy(), mtHeight)
+	}
+
+	rows := bounds.Dy() / mtHeight
+	if rows > (count+stride-1)/stride {
+		return nil, fmt.Errorf("image has %d rows of metatiles; segment only has room for %d",
+			rows, (count+stride-1)/stride)
+	}
+
+	lookup := map[color.RGBA]uint8{}
+	for i := len(pal) - 1; i >= 0; i-- {
+		lookup[color.RGBAModel.Convert(pal[i]).(color.RGBA)] = uint8(i)
+	}
+
+	tiles := []*Tile{}
+	for m := 0; m < rows*stride && m < count; m++ {
+		mx := bounds.Min.X + (m%stride)*mtWidth
+		my := bounds.Min.Y + (m/stride)*mtHeight
+
+		original := make([]*Tile, len(tileOrder))
+		for p, id := range tileOrder {
+			tile := NewTile(depth, pal)
+			tx := mx + (p%width)*8
+			ty := my + (p/width)*8
+
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					c := color.RGBAModel.Convert(img.At(tx+x, ty+y)).(color.RGBA)
+					idx, ok := lookup[c]
+					if !ok {
+						return nil, fmt.Errorf("color %v at (%d, %d) is not in the palette", c, tx+x, ty+y)
+					}
+					tile.SetColorIndex(x, y, idx)
+				}
+			}
+
+			if id < 1 || id > len(original) || original[id-1] != nil {
+				return nil, fmt.Errorf("invalid TileOrder: %v", tileOrder)
+			}
+			original[id-1] = tile
+		}
+
+		tiles = append(tiles, original...)
+	}
+
+	return tiles, nil
+}
@@ -0,0 +1,158 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// ColorSpace is the set of colors a system can display.  Convert() snaps a
+// color to the closest one the hardware can show and Encode() returns it the
+// way it is written to palette memory.  Decode() reverses Encode() and reads
+// a single color from the start of data.
+type ColorSpace interface {
+	color.Model
+	Encode(c color.Color) []byte
+	Decode(data []byte) (color.Color, error)
+}
+
+var (
+	// 5 bits per channel, little endian words: 0bbbbbgggggrrrrr
+	SNES ColorSpace = channelSpace{bits: 5, encode: encodeBgr555, decode: decodeBgr555}
+	GBC  ColorSpace = channelSpace{bits: 5, encode: encodeBgr555, decode: decodeBgr555}
+
+	// 3 bits per channel, big endian CRAM words: 0000bbb0ggg0rrr0
+	Genesis ColorSpace = channelSpace{bits: 3, encode: encodeGenesis, decode: decodeGenesis}
+
+	// 3 bits per channel, little endian VCE words: 0000000gggrrrbbb
+	PCEngine ColorSpace = channelSpace{bits: 3, encode: encodePCEngine, decode: decodePCEngine}
+
+	// 2 bits per channel, CRAM bytes: 00bbggrr
+	MasterSystem ColorSpace = channelSpace{bits: 2, encode: encodeMasterSystem, decode: decodeMasterSystem}
+)
+
+// ColorSpaceByName looks up a color space by its system's name.
+func ColorSpaceByName(name string) (ColorSpace, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "snes":
+		return SNES, nil
+	case "gbc":
+		return GBC, nil
+	case "genesis", "megadrive", "md":
+		return Genesis, nil
+	case "pce", "pcengine", "tg16":
+		return PCEngine, nil
+	case "sms", "mastersystem":
+		return MasterSystem, nil
+	}
+
+	return nil, fmt.Errorf("Unknown color space: %q", name)
+}
+
+// EncodePalette encodes every color in pal, one after the other.
+func EncodePalette(cs ColorSpace, pal color.Palette) []byte {
+	data := []byte{}
+	for _, c := range pal {
+		data = append(data, cs.Encode(c)...)
+	}
+	return data
+}
+
+// SnapPalette returns a copy of pal with each color snapped to cs.
+func SnapPalette(cs ColorSpace, pal color.Palette) color.Palette {
+	snapped := color.Palette{}
+	for _, c := range pal {
+		snapped = append(snapped, cs.Convert(c))
+	}
+	return snapped
+}
+
+// Nearest returns the index of the color in pal that is closest to c once
+// both are snapped to cs.
+func Nearest(cs ColorSpace, pal color.Palette, c color.Color) int {
+	return SnapPalette(cs, pal).Index(cs.Convert(c))
+}
+
+// channelSpace has the same number of bits for each of red, green, and
+// blue.
+type channelSpace struct {
+	bits   int
+	encode func(r, g, b uint8) []byte
+	decode func(data []byte) (r, g, b uint8)
+}
+
+func (cs channelSpace) Convert(c color.Color) color.Color {
+	r, g, b := cs.channels(c)
+	return color.RGBA{cs.expand(r), cs.expand(g), cs.expand(b), 0xFF}
+}
+
+func (cs channelSpace) Encode(c color.Color) []byte {
+	return cs.encode(cs.channels(c))
+}
+
+func (cs channelSpace) Decode(data []byte) (color.Color, error) {
+	size := len(cs.encode(0, 0, 0))
+	if len(data) < size {
+		return nil, fmt.Errorf("color needs %d bytes; got %d", size, len(data))
+	}
+
+	r, g, b := cs.decode(data[:size])
+	return color.RGBA{cs.expand(r), cs.expand(g), cs.expand(b), 0xFF}, nil
+}
+
+func (cs channelSpace) max() int {
+	return (1 << cs.bits) - 1
+}
+
+func (cs channelSpace) channels(c color.Color) (uint8, uint8, uint8) {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return quantize(rgba.R, cs.max()), quantize(rgba.G, cs.max()), quantize(rgba.B, cs.max())
+}
+
+func (cs channelSpace) expand(v uint8) uint8 {
+	return uint8((int(v)*255 + cs.max()/2) / cs.max())
+}
+
+// quantize scales an 8-bit channel down to [0, max], rounding to the closest
+// value.
+func quantize(v uint8, max int) uint8 {
+	return uint8((int(v)*max + 127) / 255)
+}
+
+func encodeBgr555(r, g, b uint8) []byte {
+	word := uint16(r) | uint16(g)<<5 | uint16(b)<<10
+	return []byte{uint8(word), uint8(word >> 8)}
+}
+
+func encodeGenesis(r, g, b uint8) []byte {
+	word := uint16(r)<<1 | uint16(g)<<5 | uint16(b)<<9
+	return []byte{uint8(word >> 8), uint8(word)}
+}
+
+func encodePCEngine(r, g, b uint8) []byte {
+	word := uint16(b) | uint16(r)<<3 | uint16(g)<<6
+	return []byte{uint8(word), uint8(word >> 8)}
+}
+
+func encodeMasterSystem(r, g, b uint8) []byte {
+	return []byte{r | g<<2 | b<<4}
+}
+
+func decodeBgr555(data []byte) (uint8, uint8, uint8) {
+	word := uint16(data[0]) | uint16(data[1])<<8
+	return uint8(word & 0x1F), uint8(word>>5) & 0x1F, uint8(word>>10) & 0x1F
+}
+
+func decodeGenesis(data []byte) (uint8, uint8, uint8) {
+	word := uint16(data[0])<<8 | uint16(data[1])
+	return uint8(word>>1) & 0x07, uint8(word>>5) & 0x07, uint8(word>>9) & 0x07
+}
+
+func decodePCEngine(data []byte) (uint8, uint8, uint8) {
+	word := uint16(data[0]) | uint16(data[1])<<8
+	return uint8(word>>3) & 0x07, uint8(word>>6) & 0x07, uint8(word) & 0x07
+}
+
+func decodeMasterSystem(data []byte) (uint8, uint8, uint8) {
+	return data[0] & 0x03, (data[0] >> 2) & 0x03, (data[0] >> 4) & 0x03
+}
@@ -0,0 +1,156 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Luma rows of the NES palette.  Black is below the first row and white is
+// above the last.
+const (
+	nesLumaBlack = -1
+	nesLumaWhite = 4
+)
+
+var (
+	NesBlack uint8 = 0x0F
+	NesWhite uint8 = 0x30
+)
+
+// FadeNes returns steps palettes that move each color in pal toward the
+// same color in target.  Colors on the NES can only be faded by moving
+// between luma rows, so each step is the nearest row to the linear fade.
+// The hue switches to the target's hue halfway through.  The last palette
+// is always target.
+func FadeNes(pal, target []uint8, steps int) ([][]uint8, error) {
+	if len(pal) != len(target) {
+		return nil, fmt.Errorf("palette and target have different lengths: %d and %d", len(pal), len(target))
+	}
+
+	if steps < 1 {
+		return nil, fmt.Errorf("need at least one step")
+	}
+
+	fades := [][]uint8{}
+	for s := 1; s <= steps; s++ {
+		t := float64(s) / float64(steps)
+		step := []uint8{}
+
+		for i := range pal {
+			srcLuma, srcHue := nesLumaHue(pal[i])
+			dstLuma, dstHue := nesLumaHue(target[i])
+
+			luma := int(math.Round(float64(srcLuma) + float64(dstLuma-srcLuma)*t))
+
+			hue := srcHue
+			if t >= 0.5 || srcHue < 0 {
+				hue = dstHue
+			}
+			if hue < 0 {
+				hue = srcHue
+			}
+
+			step = append(step, nesColor(luma, hue))
+		}
+
+		fades = append(fades, step)
+	}
+
+	return fades, nil
+}
+
+// FadeNesToColor fades every color in pal toward a single color, eg NesBlack
+// or NesWhite.
+func FadeNesToColor(pal []uint8, c uint8, steps int) ([][]uint8, error) {
+	target := make([]uint8, len(pal))
+	for i := range target {
+		target[i] = c
+	}
+	return FadeNes(pal, target, steps)
+}
+
+// nesLumaHue splits a color into its luma row and hue.  The blacks have no
+// hue and the two odd greys in column D are treated as the nearest grey in
+// column 0.
+func nesLumaHue(c uint8) (int, int) {
+	c &= 0x3F
+	row := int(c >> 4)
+	hue := int(c & 0x0F)
+
+	switch {
+	case c == NesWhite || c == 0x20:
+		return nesLumaWhite, -1
+	case c == 0x2D:
+		return 0, 0
+	case c == 0x3D:
+		return 1, 0
+	case hue >= 0x0D:
+		return nesLumaBlack, -1
+	}
+
+	return row, hue
+}
+
+func nesColor(luma, hue int) uint8 {
+	switch {
+	case luma <= nesLumaBlack:
+		return NesBlack
+	case luma >= nesLumaWhite:
+		return NesWhite
+	case hue < 0:
+		// Fading between black and white goes through the greys.
+		hue = 0
+	}
+
+	return uint8(luma<<4 | hue)
+}
+
+// FadeRGB returns steps palettes that fade each color in pal toward the same
+// color in target.  Every step is snapped to the color space, so fades only
+// use colors the hardware can show.  The last palette is always target.
+func FadeRGB(cs ColorSpace, pal, target color.Palette, steps int) ([]color.Palette, error) {
+	if len(pal) != len(target) {
+		return nil, fmt.Errorf("palette and target have different lengths: %d and %d", len(pal), len(target))
+	}
+
+	if steps < 1 {
+		return nil, fmt.Errorf("need at least one step")
+	}
+
+	fades := []color.Palette{}
+	for s := 1; s <= steps; s++ {
+		t := float64(s) / float64(steps)
+		step := color.Palette{}
+
+		for i := range pal {
+			src := color.RGBAModel.Convert(cs.Convert(pal[i])).(color.RGBA)
+			dst := color.RGBAModel.Convert(cs.Convert(target[i])).(color.RGBA)
+
+			step = append(step, cs.Convert(color.RGBA{
+				lerp(src.R, dst.R, t),
+				lerp(src.G, dst.G, t),
+				lerp(src.B, dst.B, t),
+				0xFF,
+			}))
+		}
+
+		fades = append(fades, step)
+	}
+
+	return fades, nil
+}
+
+// FadeRGBToColor fades every color in pal toward a single color, eg
+// color.Black or color.White.
+func FadeRGBToColor(cs ColorSpace, pal color.Palette, c color.Color, steps int) ([]color.Palette, error) {
+	target := make(color.Palette, len(pal))
+	for i := range target {
+		target[i] = c
+	}
+	return FadeRGB(cs, pal, target, steps)
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+}
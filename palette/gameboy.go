@@ -0,0 +1,129 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// The four DMG shades, lightest first, as they look on different screens.
+var (
+	DmgGreen = color.Palette{
+		color.RGBA{0x9B, 0xBC, 0x0F, 0xFF},
+		color.RGBA{0x8B, 0xAC, 0x0F, 0xFF},
+		color.RGBA{0x30, 0x62, 0x30, 0xFF},
+		color.RGBA{0x0F, 0x38, 0x0F, 0xFF},
+	}
+
+	DmgPocket = color.Palette{
+		color.RGBA{0xC5, 0xCA, 0xA4, 0xFF},
+		color.RGBA{0x8C, 0x92, 0x6B, 0xFF},
+		color.RGBA{0x4A, 0x51, 0x38, 0xFF},
+		color.RGBA{0x18, 0x18, 0x18, 0xFF},
+	}
+
+	// Default colors of the BGB emulator
+	DmgBgb = color.Palette{
+		color.RGBA{0xE0, 0xF8, 0xD0, 0xFF},
+		color.RGBA{0x88, 0xC0, 0x70, 0xFF},
+		color.RGBA{0x34, 0x68, 0x56, 0xFF},
+		color.RGBA{0x08, 0x18, 0x20, 0xFF},
+	}
+)
+
+// DmgTint looks up one of the DMG shade palettes by name.
+func DmgTint(name string) (color.Palette, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "green", "classic", "dmg":
+		return DmgGreen, nil
+	case "pocket", "grey", "gray", "mgb":
+		return DmgPocket, nil
+	case "bgb":
+		return DmgBgb, nil
+	}
+
+	return nil, fmt.Errorf("Unknown DMG tint: %q", name)
+}
+
+// Identity mapping of color indexes to shades.  This is what most games
+// write to BGP.
+const DmgDefaultRegister uint8 = 0xE4
+
+// EncodeDmgRegister packs the shade of each of the four color indexes into
+// a BGP, OBP0, or OBP1 register value.  Color 0 is in the low bits.
+func EncodeDmgRegister(shades [4]uint8) uint8 {
+	var reg uint8
+	for i, s := range shades {
+		reg |= (s & 0x03) << (i * 2)
+	}
+	return reg
+}
+
+func DecodeDmgRegister(reg uint8) [4]uint8 {
+	shades := [4]uint8{}
+	for i := range shades {
+		shades[i] = (reg >> (i * 2)) & 0x03
+	}
+	return shades
+}
+
+// DmgPalette returns the colors each index is displayed as with the given
+// BGP register value.
+func DmgPalette(tint color.Palette, reg uint8) color.Palette {
+	pal := color.Palette{}
+	for _, s := range DecodeDmgRegister(reg) {
+		pal = append(pal, tint[s])
+	}
+	return pal
+}
+
+// DmgObjPalette is DmgPalette for OBP0 and OBP1, where color 0 is always
+// transparent whatever the register says.
+func DmgObjPalette(tint color.Palette, reg uint8) color.Palette {
+	pal := DmgPalette(tint, reg)
+	pal[0] = color.RGBA{}
+	return pal
+}
+
+// ParseGbcPalette parses four comma separated HTML colors, eg
+// "#FFFFFF,#AAAAAA,#555555,#000000", and snaps them to the colors a GBC can
+// display.
+func ParseGbcPalette(s string) (color.Palette, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("GBC palettes need exactly four colors: %q", s)
+	}
+
+	pal := color.Palette{}
+	for _, p := range parts {
+		c, err := ParseHexColor(p)
+		if err != nil {
+			return nil, err
+		}
+		pal = append(pal, GBC.Convert(c))
+	}
+
+	return pal, nil
+}
+
+// EncodeGbcPalettes encodes up to eight four color palettes as the 64 bytes
+// written through BCPD or OCPD with auto-increment enabled.
+func EncodeGbcPalettes(pals []color.Palette) ([]byte, error) {
+	if len(pals) > 8 {
+		return nil, fmt.Errorf("too many palettes: %d; max: 8", len(pals))
+	}
+
+	data := []byte{}
+	for i, pal := range pals {
+		if len(pal) > 4 {
+			return nil, fmt.Errorf("palette at index %d contains too many colors: %d; max: 4", i, len(pal))
+		}
+
+		data = append(data, EncodePalette(GBC, pal)...)
+		for j := len(pal); j < 4; j++ {
+			data = append(data, GBC.Encode(color.Black)...)
+		}
+	}
+
+	return data, nil
+}
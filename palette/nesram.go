@@ -0,0 +1,222 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/go-retroimg/export"
+)
+
+// PaletteRam is an image of the PPU's palette memory at $3F00-$3F1F.  The
+// first 16 bytes are the four background palettes, the last 16 the four
+// sprite palettes.
+type PaletteRam [32]uint8
+
+// ParseNesColor parses a single PPU color index, eg "0F", "$0F" or "0x0F".
+func ParseNesColor(s string) (uint8, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+
+	val, err := strconv.ParseUint(s, 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid NES color %q: %w", s, err)
+	}
+
+	if val > 0x3F {
+		return 0, fmt.Errorf("Invalid NES color $%02X: out of range", val)
+	}
+
+	return uint8(val), nil
+}
+
+// ParseNesPalette parses four comma separated color indexes, eg
+// "0F,00,10,20".
+func ParseNesPalette(s string) ([4]uint8, error) {
+	pal := [4]uint8{}
+
+	parts := strings.Split(s, ",")
+	if len(parts) < 4 {
+		return pal, fmt.Errorf("Too few colors")
+	}
+	if len(parts) > 4 {
+		return pal, fmt.Errorf("Too many colors")
+	}
+
+	for i, p := range parts {
+		c, err := ParseNesColor(p)
+		if err != nil {
+			return pal, err
+		}
+		pal[i] = c
+	}
+
+	return pal, nil
+}
+
+// NewPaletteRam lays out up to eight subpalettes, background palettes first,
+// the way they need to be written to the PPU.  The first color of the first
+// palette is the backdrop and it is written to every shared entry, including
+// the $3F10 mirrors, so uploading all 32 bytes in one go doesn't clobber it.
+func NewPaletteRam(pals [][4]uint8) (*PaletteRam, error) {
+	if len(pals) > 8 {
+		return nil, fmt.Errorf("too many palettes: %d; max: 8", len(pals))
+	} else if len(pals) == 0 {
+		return nil, fmt.Errorf("too few palettes")
+	}
+
+	ram := &PaletteRam{}
+	backdrop := pals[0][0]
+	for i := 0; i < 8; i++ {
+		ram[i*4] = backdrop
+		if i >= len(pals) {
+			ram[i*4+1] = backdrop
+			ram[i*4+2] = backdrop
+			ram[i*4+3] = backdrop
+			continue
+		}
+
+		for j := 1; j < 4; j++ {
+			if pals[i][j] > 0x3F {
+				return nil, fmt.Errorf("palette %d color %d out of range: $%02X", i, j, pals[i][j])
+			}
+			ram[i*4+j] = pals[i][j]
+		}
+	}
+
+	return ram, nil
+}
+
+// ParsePaletteRam parses palettes in the form taken by ParseNesPalette and
+// lays them out with NewPaletteRam.  Warnings from ValidateNesPalettes are
+// returned along with the palette RAM.
+func ParsePaletteRam(specs []string) (*PaletteRam, []error, error) {
+	pals := [][4]uint8{}
+	for _, spec := range specs {
+		p, err := ParseNesPalette(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		pals = append(pals, p)
+	}
+
+	ram, err := NewPaletteRam(pals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ram, ValidateNesPalettes(pals), nil
+}
+
+// ValidateNesPalettes returns warnings for colors that will not display the
+// way they look in the source palettes.  None of these stop the palettes
+// from being used.
+func ValidateNesPalettes(pals [][4]uint8) []error {
+	warnings := []error{}
+	if len(pals) == 0 {
+		return warnings
+	}
+
+	backdrop := pals[0][0]
+	for i, pal := range pals {
+		for j, c := range pal {
+			if c == 0x0D {
+				warnings = append(warnings, fmt.Errorf("palette %d color %d is $0D which is blacker than black and can upset the sync of some TVs; use $0F instead", i, j))
+			}
+		}
+
+		if i == 0 || pal[0] == backdrop {
+			continue
+		}
+
+		addr := 0x3F00 + i*4
+		if addr == 0x3F10 {
+			warnings = append(warnings, fmt.Errorf("palette %d color 0 ($%02X) at $3F10 is a mirror of the backdrop at $3F00 and would overwrite $%02X", i, pal[0], backdrop))
+		} else {
+			warnings = append(warnings, fmt.Errorf("palette %d color 0 ($%02X) at $%04X is never displayed; the backdrop ($%02X) is used instead", i, pal[0], addr, backdrop))
+		}
+	}
+
+	return warnings
+}
+
+// Palettes returns all eight subpalettes as colors from the given ColorMap.
+func (ram *PaletteRam) Palettes(cm ColorMap) []color.Palette {
+	pals := []color.Palette{}
+	for i := 0; i < 8; i++ {
+		pals = append(pals, cm.NesPalette(
+			fmt.Sprintf("%02x", ram[i*4]),
+			fmt.Sprintf("%02x", ram[i*4+1]),
+			fmt.Sprintf("%02x", ram[i*4+2]),
+			fmt.Sprintf("%02x", ram[i*4+3]),
+		))
+	}
+	return pals
+}
+
+func (ram *PaletteRam) WriteBin(w io.Writer) error {
+	_, err := w.Write(ram[:])
+	return err
+}
+
+func (ram *PaletteRam) WriteAsm(w io.Writer) error {
+	for i := 0; i < 8; i++ {
+		_, err := fmt.Fprintf(w, ".byte $%02X, $%02X, $%02X, $%02X ; $%04X\n",
+			ram[i*4], ram[i*4+1], ram[i*4+2], ram[i*4+3], 0x3F00+i*4)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the palette RAM to filename the way export.WriteFile
+// does, except that assembly is written with WriteAsm if opts is nil.
+func (ram *PaletteRam) WriteFile(filename string, opts *export.Options) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".inc", ".asm", ".s":
+		if opts != nil {
+			break
+		}
+
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return ram.WriteAsm(file)
+	}
+
+	return export.WriteFile(filename, ram.table("palettes"), opts)
+}
+
+// WriteAsmOptions writes the palette RAM as a table called name, one block
+// per subpalette.
+func (ram *PaletteRam) WriteAsmOptions(w io.Writer, name string, opts export.Options) error {
+	return opts.WriteTable(w, ram.table(name))
+}
+
+func (ram *PaletteRam) table(name string) export.Table {
+	return export.Table{
+		Name:   name,
+		Blocks: export.Blocks(ram[:], 4),
+		Comment: func(i int) string {
+			return fmt.Sprintf("$%04X", 0x3F00+i*4)
+		},
+	}
+}
+
+// CArray returns the palette RAM as a C array called name, with a macro for
+// the number of subpalettes.
+func (ram *PaletteRam) CArray(name string) export.CArray {
+	return export.CArray{
+		Table:   ram.table(name),
+		Defines: []export.Define{{Name: "COUNT", Value: len(ram) / 4}},
+	}
+}
@@ -0,0 +1,153 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+type PpuRevision int
+
+const (
+	PPU_2C02 PpuRevision = iota // NTSC
+	PPU_2C07                    // PAL
+)
+
+func (rev *PpuRevision) UnmarshalText(b []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "2c02", "ntsc":
+		*rev = PPU_2C02
+	case "2c07", "pal":
+		*rev = PPU_2C07
+	default:
+		return fmt.Errorf("Invalid PPU revision: %q", string(b))
+	}
+
+	return nil
+}
+
+func (rev PpuRevision) String() string {
+	switch rev {
+	case PPU_2C02:
+		return "PPU_2C02"
+	case PPU_2C07:
+		return "PPU_2C07"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// NtscParams are the knobs of a TV decoding the PPU's composite signal.
+// Hue is in degrees and Brightness is an offset, so zero leaves them
+// unchanged.  Saturation and Contrast are multipliers: zero saturation gives
+// grey and zero contrast gives black, so start from DefaultNtscParams rather
+// than the zero value.  A Gamma of zero is treated as 2.2.
+type NtscParams struct {
+	Hue        float64
+	Saturation float64
+	Contrast   float64
+	Brightness float64
+	Gamma      float64
+
+	Revision PpuRevision
+}
+
+var DefaultNtscParams = NtscParams{
+	Hue:        0.0,
+	Saturation: 1.0,
+	Contrast:   1.0,
+	Brightness: 0.0,
+	Gamma:      2.2,
+	Revision:   PPU_2C02,
+}
+
+// Composite signal voltages relative to sync for each luma row.  The first
+// four are the low side of the square wave, the last four the high side.
+var ntscLevels = [8]float64{
+	0.350, 0.518, 0.962, 1.550,
+	1.094, 1.506, 1.962, 1.962,
+}
+
+const (
+	ntscBlack = 0.518
+	ntscWhite = 1.962
+)
+
+// GenerateNes decodes each of the 64 PPU colors the way a TV would and
+// returns them as a ColorMap keyed the same way as Nes_2C02.
+func GenerateNes(params NtscParams) ColorMap {
+	cm := ColorMap{}
+	for i := 0; i < 64; i++ {
+		cm[fmt.Sprintf("%02x", i)] = params.decode(uint8(i))
+	}
+	return cm
+}
+
+func (params NtscParams) decode(pixel uint8) color.RGBA {
+	hue := int(pixel & 0x0F)
+	level := int(pixel>>4) & 0x03
+	if hue > 13 {
+		level = 1
+	}
+
+	low := ntscLevels[level]
+	high := ntscLevels[4+level]
+	if hue == 0 {
+		low = high
+	}
+	if hue > 12 {
+		high = low
+	}
+
+	// The 2C07 alternates the phase of the color burst on every line, which
+	// a PAL set averages out to a shift of half a color phase.
+	shift := params.Hue / 30.0
+	if params.Revision == PPU_2C07 {
+		shift -= 0.5
+	}
+
+	// Color 1 sits four phases (120 degrees) away from the color burst.
+	shift += 4.0
+
+	var y, i, q float64
+	for p := 0; p < 12; p++ {
+		signal := low
+		if (hue+p)%12 < 6 {
+			signal = high
+		}
+		signal = (signal - ntscBlack) / (ntscWhite - ntscBlack)
+
+		y += signal / 12.0
+		i += signal / 12.0 * math.Cos(math.Pi*(float64(p)+shift)/6.0)
+		q += signal / 12.0 * math.Sin(math.Pi*(float64(p)+shift)/6.0)
+	}
+
+	y = y*params.Contrast + params.Brightness
+	i *= params.Saturation * params.Contrast
+	q *= params.Saturation * params.Contrast
+
+	return color.RGBA{
+		params.gamma(y + 0.946882*i + 0.623557*q),
+		params.gamma(y - 0.274788*i - 0.635691*q),
+		params.gamma(y - 1.108545*i + 1.709007*q),
+		0xFF,
+	}
+}
+
+func (params NtscParams) gamma(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+
+	g := params.Gamma
+	if g <= 0 {
+		g = 2.2
+	}
+
+	v = math.Pow(v, 2.2/g) * 255.0
+	if v >= 255.0 {
+		return 0xFF
+	}
+	return uint8(v + 0.5)
+}
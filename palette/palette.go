@@ -46,6 +46,49 @@ func (cm ColorMap) NesPalette(C1, C2, C3, C4 string) color.Palette {
 	return color.Palette{c1, c2, c3, c4}
 }
 
+// NewColorMap keys a palette the same way as Nes_2C02, eg. the 64 colors
+// read from a .pal file.
+func NewColorMap(pal color.Palette) ColorMap {
+	cm := ColorMap{}
+	for i, c := range pal {
+		cm[fmt.Sprintf("%02x", i)] = c
+	}
+	return cm
+}
+
+// WritePal writes the 64 NES colors as raw 8-bit RGB, the .pal format read
+// by most emulators.
+func (cm ColorMap) WritePal(w io.Writer) error {
+	buf := []byte{}
+	for i := 0; i < 64; i++ {
+		c, ok := cm[fmt.Sprintf("%02x", i)]
+		if !ok {
+			c = cm["0f"]
+		}
+
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		buf = append(buf, rgba.R, rgba.G, rgba.B)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ParseHexColor parses an HTML style color, eg "#00AA55".
+func ParseHexColor(s string) (color.Color, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) != 6 {
+		return nil, fmt.Errorf("Invalid color %q", s)
+	}
+
+	val, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid color %q: %w", s, err)
+	}
+
+	return color.RGBA{uint8(val >> 16), uint8(val >> 8), uint8(val), 0xFF}, nil
+}
+
 type PaletteFormat int
 
 const (
@@ -95,7 +138,7 @@ func readRawRGB(r io.Reader) (color.Palette, error) {
 			break
 		}
 
-		pal = append(pal, color.RGBA{uint8(buf[0]), uint8(buf[3]), uint8(buf[2]), 0xFF})
+		pal = append(pal, color.RGBA{uint8(buf[0]), uint8(buf[1]), uint8(buf[2]), 0xFF})
 	}
 
 	if errors.Is(err, io.EOF) {
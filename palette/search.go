@@ -0,0 +1,162 @@
+package palette
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+)
+
+// PaletteMatch is a run of palette entries that holds every color that was
+// searched for.
+type PaletteMatch struct {
+	Offset int64
+
+	// Number of entries in the run, not bytes.
+	Length int
+}
+
+// NES colors that look the same on screen.  Every black is treated as $0F
+// and $20 as $30.
+var nesEquivalent = map[uint8]uint8{
+	0x0D: 0x0F, 0x0E: 0x0F, 0x1D: 0x0F, 0x1E: 0x0F, 0x1F: 0x0F,
+	0x2E: 0x0F, 0x2F: 0x0F, 0x3E: 0x0F, 0x3F: 0x0F,
+	0x20: 0x30,
+}
+
+func nesCanonical(c uint8) uint8 {
+	if eq, ok := nesEquivalent[c]; ok {
+		return eq
+	}
+	return c
+}
+
+// NesIndex returns the index of the color in cm closest to c.  Blacks are
+// always returned as $0F and white as $30.
+func NesIndex(cm ColorMap, c color.Color) uint8 {
+	r, g, b, _ := c.RGBA()
+
+	var best uint8
+	bestDist := -1
+	for i := 0; i < 0x40; i++ {
+		nc, ok := cm[fmt.Sprintf("%02x", i)]
+		if !ok {
+			continue
+		}
+
+		nr, ng, nb, _ := nc.RGBA()
+		dr, dg, db := int(r>>8)-int(nr>>8), int(g>>8)-int(ng>>8), int(b>>8)-int(nb>>8)
+		dist := dr*dr + dg*dg + db*db
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = uint8(i), dist
+		}
+	}
+
+	return nesCanonical(best)
+}
+
+// FindNesPalette looks for runs of NES palette indexes in data that contain
+// all of colors, in any order.  Colors that look alike, like the different
+// blacks, are treated as the same color.  Every byte of a run has to be a
+// valid color and runs are at most maxSpan bytes long.
+func FindNesPalette(data []byte, colors []uint8, maxSpan int) []PaletteMatch {
+	targets := map[uint32]bool{}
+	for _, c := range colors {
+		targets[uint32(nesCanonical(c&0x3F))] = true
+	}
+
+	return findEntries(len(data), 1, targets, maxSpan, func(offset int) (uint32, bool) {
+		if data[offset] >= 0x40 {
+			return 0, false
+		}
+		return uint32(nesCanonical(data[offset])), true
+	})
+}
+
+// FindPalette looks for runs of colors encoded in cs, eg. BGR555 for the
+// SNES, that contain all of colors in any order.  Runs can start at any
+// byte, are made only of valid encodings, and are at most maxSpan colors
+// long.
+func FindPalette(data []byte, cs ColorSpace, colors color.Palette, maxSpan int) []PaletteMatch {
+	size := len(cs.Encode(color.Black))
+	if len(data) < size {
+		return nil
+	}
+
+	targets := map[uint32]bool{}
+	for _, c := range colors {
+		targets[entryKey(cs.Encode(c))] = true
+	}
+
+	return findEntries(len(data)-size+1, size, targets, maxSpan, func(offset int) (uint32, bool) {
+		entry := data[offset : offset+size]
+
+		// Unused bits have to be clear.
+		c, err := cs.Decode(entry)
+		if err != nil || !bytes.Equal(cs.Encode(c), entry) {
+			return 0, false
+		}
+		return entryKey(entry), true
+	})
+}
+
+func entryKey(entry []byte) uint32 {
+	var key uint32
+	for _, b := range entry {
+		key = key<<8 | uint32(b)
+	}
+	return key
+}
+
+// findEntries does the searching for FindNesPalette() and FindPalette().
+// entry returns the value of the entry at a byte offset and whether it's a
+// valid color at all.
+func findEntries(count, size int, targets map[uint32]bool, maxSpan int, entry func(offset int) (uint32, bool)) []PaletteMatch {
+	matches := []PaletteMatch{}
+	if len(targets) == 0 {
+		return matches
+	}
+
+	// Starts already inside a match with the same alignment.
+	covered := make([]bool, count)
+
+	for start := 0; start < count; start++ {
+		if covered[start] {
+			continue
+		}
+
+		if key, ok := entry(start); !ok || !targets[key] {
+			continue
+		}
+
+		found := map[uint32]bool{}
+		for n := 0; n < maxSpan; n++ {
+			offset := start + n*size
+			if offset >= count {
+				break
+			}
+
+			key, ok := entry(offset)
+			if !ok {
+				break
+			}
+
+			if targets[key] {
+				found[key] = true
+			}
+
+			if len(found) == len(targets) {
+				matches = append(matches, PaletteMatch{
+					Offset: int64(start),
+					Length: n + 1,
+				})
+
+				for i := 0; i <= n; i++ {
+					covered[start+i*size] = true
+				}
+				break
+			}
+		}
+	}
+
+	return matches
+}
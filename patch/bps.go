@@ -0,0 +1,218 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const bpsMagic = "BPS1"
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// CreateBPS returns a BPS patch that turns source into target.  Unchanged
+// bytes are copied from the source and everything else is stored in the
+// patch.
+func CreateBPS(source, target []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(bpsMagic)
+	writeBPSNumber(buf, uint64(len(source)))
+	writeBPSNumber(buf, uint64(len(target)))
+	writeBPSNumber(buf, 0) // no metadata
+
+	i := 0
+	for i < len(target) {
+		start := i
+		if i < len(source) && source[i] == target[i] {
+			for i < len(target) && i < len(source) && source[i] == target[i] {
+				i++
+			}
+			writeBPSNumber(buf, uint64(i-start-1)<<2|bpsSourceRead)
+			continue
+		}
+
+		for i < len(target) && (i >= len(source) || source[i] != target[i]) {
+			i++
+		}
+		writeBPSNumber(buf, uint64(i-start-1)<<2|bpsTargetRead)
+		buf.Write(target[start:i])
+	}
+
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(source))
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(target))
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return buf.Bytes()
+}
+
+// ApplyBPS returns the target of a BPS patch.  The checksums of the source,
+// the patch, and the result are all verified.
+func ApplyBPS(source, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte(bpsMagic)) {
+		return nil, fmt.Errorf("missing BPS header")
+	}
+
+	if len(patch) < len(bpsMagic)+12 {
+		return nil, fmt.Errorf("BPS patch is truncated")
+	}
+
+	footer := patch[len(patch)-12:]
+	sourceCrc := binary.LittleEndian.Uint32(footer[0:])
+	targetCrc := binary.LittleEndian.Uint32(footer[4:])
+	patchCrc := binary.LittleEndian.Uint32(footer[8:])
+
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != patchCrc {
+		return nil, fmt.Errorf("BPS patch checksum mismatch")
+	}
+
+	if crc32.ChecksumIEEE(source) != sourceCrc {
+		return nil, fmt.Errorf("source checksum mismatch; patch is for a different file")
+	}
+
+	r := &bpsReader{data: patch[:len(patch)-12], pos: len(bpsMagic)}
+
+	sourceSize, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+
+	targetSize, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+
+	metaSize, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+	r.pos += int(metaSize)
+
+	if sourceSize != uint64(len(source)) {
+		return nil, fmt.Errorf("source is %d bytes; patch expects %d", len(source), sourceSize)
+	}
+
+	target := make([]byte, 0, targetSize)
+	var sourceRel, targetRel int64
+
+	for r.pos < len(r.data) {
+		cmd, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+
+		length := int(cmd>>2) + 1
+		switch cmd & 0x03 {
+		case bpsSourceRead:
+			start := len(target)
+			if start+length > len(source) {
+				return nil, fmt.Errorf("SourceRead past the end of the source")
+			}
+			target = append(target, source[start:start+length]...)
+
+		case bpsTargetRead:
+			if r.pos+length > len(r.data) {
+				return nil, fmt.Errorf("BPS patch is truncated")
+			}
+			target = append(target, r.data[r.pos:r.pos+length]...)
+			r.pos += length
+
+		case bpsSourceCopy:
+			offset, err := r.signed()
+			if err != nil {
+				return nil, err
+			}
+			sourceRel += offset
+			if sourceRel < 0 || sourceRel+int64(length) > int64(len(source)) {
+				return nil, fmt.Errorf("SourceCopy out of range")
+			}
+			target = append(target, source[sourceRel:sourceRel+int64(length)]...)
+			sourceRel += int64(length)
+
+		case bpsTargetCopy:
+			offset, err := r.signed()
+			if err != nil {
+				return nil, err
+			}
+			targetRel += offset
+			if targetRel < 0 || targetRel >= int64(len(target)) {
+				return nil, fmt.Errorf("TargetCopy out of range")
+			}
+			// Byte by byte since the copy can overlap its own output.
+			for i := 0; i < length; i++ {
+				target = append(target, target[targetRel])
+				targetRel++
+			}
+		}
+	}
+
+	if uint64(len(target)) != targetSize {
+		return nil, fmt.Errorf("result is %d bytes; patch expects %d", len(target), targetSize)
+	}
+
+	if crc32.ChecksumIEEE(target) != targetCrc {
+		return nil, fmt.Errorf("result checksum mismatch")
+	}
+
+	return target, nil
+}
+
+// Numbers are stored seven bits at a time, least significant first, with the
+// high bit set on the last byte.  One is subtracted after each byte so every
+// value has exactly one encoding.
+func writeBPSNumber(buf *bytes.Buffer, val uint64) {
+	for {
+		x := uint8(val & 0x7F)
+		val >>= 7
+		if val == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		val--
+	}
+}
+
+type bpsReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bpsReader) number() (uint64, error) {
+	var val uint64
+	var shift uint64 = 1
+
+	for {
+		if r.pos >= len(r.data) {
+			return 0, fmt.Errorf("BPS patch is truncated")
+		}
+
+		x := r.data[r.pos]
+		r.pos++
+
+		val += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return val, nil
+		}
+		shift <<= 7
+		val += shift
+	}
+}
+
+// Relative offsets have their sign in the lowest bit.
+func (r *bpsReader) signed() (int64, error) {
+	val, err := r.number()
+	if err != nil {
+		return 0, err
+	}
+
+	if val&1 != 0 {
+		return -int64(val >> 1), nil
+	}
+	return int64(val >> 1), nil
+}
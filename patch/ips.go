@@ -0,0 +1,136 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	ipsMagic  = "PATCH"
+	ipsFooter = "EOF"
+
+	ipsMaxOffset = 0xFFFFFF
+	ipsMaxRecord = 0xFFFF
+
+	// An offset that would read back as the footer.
+	ipsEofOffset = 0x454F46
+)
+
+// CreateIPS returns an IPS patch that turns original into modified.  IPS
+// can only address the first 16M of a file.  If modified is shorter than
+// original, the truncation extension is used.
+func CreateIPS(original, modified []byte) ([]byte, error) {
+	if len(modified) > ipsMaxOffset+1 {
+		return nil, fmt.Errorf("file too large for IPS: %d bytes", len(modified))
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(ipsMagic)
+
+	i := 0
+	for i < len(modified) {
+		if i < len(original) && original[i] == modified[i] {
+			i++
+			continue
+		}
+
+		start := i
+		if start == ipsEofOffset {
+			start--
+		}
+
+		end := i
+		for end < len(modified) && end-start < ipsMaxRecord {
+			if end < len(original) && original[end] == modified[end] {
+				break
+			}
+			end++
+		}
+
+		writeIPSRecord(buf, start, modified[start:end])
+		i = end
+	}
+
+	buf.WriteString(ipsFooter)
+
+	if len(modified) < len(original) {
+		buf.Write([]byte{uint8(len(modified) >> 16), uint8(len(modified) >> 8), uint8(len(modified))})
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeIPSRecord(buf *bytes.Buffer, offset int, data []byte) {
+	buf.Write([]byte{uint8(offset >> 16), uint8(offset >> 8), uint8(offset)})
+
+	if len(data) > 3 && bytes.Count(data, data[:1]) == len(data) {
+		// RLE record
+		buf.Write([]byte{0, 0, uint8(len(data) >> 8), uint8(len(data)), data[0]})
+		return
+	}
+
+	buf.Write([]byte{uint8(len(data) >> 8), uint8(len(data))})
+	buf.Write(data)
+}
+
+// ApplyIPS returns a copy of original with the patch applied.
+func ApplyIPS(original, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte(ipsMagic)) {
+		return nil, fmt.Errorf("missing IPS header")
+	}
+
+	out := make([]byte, len(original))
+	copy(out, original)
+
+	pos := len(ipsMagic)
+	for {
+		if pos+3 > len(patch) {
+			return nil, fmt.Errorf("IPS patch is truncated")
+		}
+
+		if string(patch[pos:pos+3]) == ipsFooter {
+			pos += 3
+			break
+		}
+
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		pos += 3
+
+		if pos+2 > len(patch) {
+			return nil, fmt.Errorf("IPS patch is truncated")
+		}
+		size := int(patch[pos])<<8 | int(patch[pos+1])
+		pos += 2
+
+		var data []byte
+		if size == 0 {
+			if pos+3 > len(patch) {
+				return nil, fmt.Errorf("IPS patch is truncated")
+			}
+			size = int(patch[pos])<<8 | int(patch[pos+1])
+			data = bytes.Repeat(patch[pos+2:pos+3], size)
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, fmt.Errorf("IPS patch is truncated")
+			}
+			data = patch[pos : pos+size]
+			pos += size
+		}
+
+		if offset+len(data) > len(out) {
+			out = append(out, make([]byte, offset+len(data)-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	// Truncation extension
+	if pos+3 == len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+
+	return out, nil
+}
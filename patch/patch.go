@@ -0,0 +1,18 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Apply detects the format of patch and applies it to a copy of original.
+func Apply(original, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte(ipsMagic)):
+		return ApplyIPS(original, patch)
+	case bytes.HasPrefix(patch, []byte(bpsMagic)):
+		return ApplyBPS(original, patch)
+	}
+
+	return nil, fmt.Errorf("unknown patch format")
+}
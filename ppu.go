@@ -0,0 +1,432 @@
+package retroimg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/zorchenhimer/go-retroimg/palette"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+// NesPpu holds dumps of the NES PPU's memory, as saved by most emulators.
+type NesPpu struct {
+	// Both pattern tables, 8K.
+	Chr []byte
+
+	// Nametable RAM.  2K, or 4K for four screen mirroring.
+	Nametables []byte
+
+	PaletteRam palette.PaletteRam
+
+	// 256 bytes of sprite data.  Sprites aren't drawn without it.
+	Oam []byte
+
+	Mirroring rom.Mirroring
+
+	// Pattern tables used by the background and by 8x8 sprites, 0 or 1.
+	// These are bits 4 and 3 of $2000.
+	BgTable     int
+	SpriteTable int
+
+	// 8x16 sprites take their pattern table from bit 0 of the tile index.
+	TallSprites bool
+
+	Colors palette.ColorMap
+}
+
+// Size of a dump of the PPU's whole address space, $0000-$3FFF.
+const NesPpuDumpSize = 0x4000
+
+// NewNesPpuFromDump splits a dump of the PPU's address space into its
+// pattern tables, nametables, and palette RAM.  The nametables have already
+// been mirrored in a dump like this so four screen mirroring is used.
+func NewNesPpuFromDump(dump []byte) (*NesPpu, error) {
+	if len(dump) != NesPpuDumpSize {
+		return nil, fmt.Errorf("PPU dump is %d bytes; expected %d", len(dump), NesPpuDumpSize)
+	}
+
+	ppu := &NesPpu{
+		Chr:        dump[0x0000:0x2000],
+		Nametables: dump[0x2000:0x3000],
+		Mirroring:  rom.MirrorFourScreen,
+		Colors:     palette.Nes_2C02,
+	}
+	copy(ppu.PaletteRam[:], dump[0x3F00:0x3F20])
+
+	return ppu, nil
+}
+
+func (ppu *NesPpu) tiles() ([]*Tile, error) {
+	if len(ppu.Chr) != 0x2000 {
+		return nil, fmt.Errorf("CHR is %d bytes; expected 8192", len(ppu.Chr))
+	}
+
+	return NewRawChr(bytes.NewReader(ppu.Chr)).ReadAllTiles(BD_2bpp)
+}
+
+// palettes returns the eight subpalettes.  Color 0 of every background
+// palette is always drawn as the backdrop at $3F00.
+func (ppu *NesPpu) palettes() []color.Palette {
+	cm := ppu.Colors
+	if cm == nil {
+		cm = palette.Nes_2C02
+	}
+
+	pals := ppu.PaletteRam.Palettes(cm)
+	for i := 1; i < 4; i++ {
+		pals[i][0] = pals[0][0]
+	}
+	return pals
+}
+
+// Nametable returns one of the four nametables at $2000, $2400, $2800, or
+// $2C00 after mirroring.  The last two rows of the Tilemap are unused.
+func (ppu *NesPpu) Nametable(n int) (*Tilemap, error) {
+	tiles, err := ppu.tiles()
+	if err != nil {
+		return nil, err
+	}
+
+	return ppu.nametable(n, tiles, ppu.palettes())
+}
+
+func (ppu *NesPpu) nametable(n int, tiles []*Tile, pals []color.Palette) (*Tilemap, error) {
+	page := ppu.Mirroring.Nametable(n)
+	if (page+1)*0x400 > len(ppu.Nametables) {
+		return nil, fmt.Errorf("nametable RAM is %d bytes; %s needs %d",
+			len(ppu.Nametables), ppu.Mirroring, (page+1)*0x400)
+	}
+	data := ppu.Nametables[page*0x400 : (page+1)*0x400]
+
+	tm, err := NewTilemap(CS_8x8, BD_2bpp, pals[:4])
+	if err != nil {
+		return nil, err
+	}
+	tm.Palettes = pals[:4]
+
+	for row := 0; row < 30; row++ {
+		for col := 0; col < 32; col++ {
+			// Each attribute byte covers 4x4 tiles, two bits per 2x2.
+			attr := data[0x3C0+(row/4)*8+col/4]
+			shift := ((row/2)%2)*4 + ((col/2)%2)*2
+			pal := int(attr>>shift) & 0x03
+
+			tile := *tiles[ppu.BgTable*256+int(data[row*32+col])]
+			tile.Palette = pals[pal]
+
+			md := &tm.Tiles[row*32+col]
+			md.Tile8 = &tile
+			md.Palette = pals[pal]
+			md.PaletteIdx = pal
+		}
+	}
+
+	return tm, nil
+}
+
+// Render draws all four nametables into a 512x480 image.  If sprites is
+// true and there is OAM data, sprites are drawn on top as they would appear
+// with the screen scrolled to scroll.
+func (ppu *NesPpu) Render(sprites bool, scroll image.Point) (*image.RGBA, error) {
+	tiles, err := ppu.tiles()
+	if err != nil {
+		return nil, err
+	}
+	pals := ppu.palettes()
+
+	img := image.NewRGBA(image.Rect(0, 0, 512, 480))
+	opaque := make([]bool, 512*480)
+
+	for n := 0; n < 4; n++ {
+		tm, err := ppu.nametable(n, tiles, pals)
+		if err != nil {
+			return nil, err
+		}
+
+		ox, oy := (n%2)*256, (n/2)*240
+		for y := 0; y < 240; y++ {
+			for x := 0; x < 256; x++ {
+				img.Set(ox+x, oy+y, tm.At(x, y))
+				md := &tm.Tiles[(y/8)*32+x/8]
+				opaque[(oy+y)*512+ox+x] = md.ColorIndexAt(x%8, y%8) != 0
+			}
+		}
+	}
+
+	if !sprites || len(ppu.Oam) == 0 {
+		return img, nil
+	}
+
+	if len(ppu.Oam) != 256 {
+		return nil, fmt.Errorf("OAM is %d bytes; expected 256", len(ppu.Oam))
+	}
+
+	height := 8
+	if ppu.TallSprites {
+		height = 16
+	}
+
+	// The first sprite with a pixel at a spot wins even if it's behind the
+	// background, hiding any later sprites there.
+	drawn := make([]bool, 512*480)
+
+	for i := 0; i < 64; i++ {
+		sy, idx, attr, sx := int(ppu.Oam[i*4]), int(ppu.Oam[i*4+1]), ppu.Oam[i*4+2], int(ppu.Oam[i*4+3])
+
+		// Sprites are drawn a line below their Y and anything from $EF
+		// down is off screen.
+		if sy >= 0xEF {
+			continue
+		}
+		sy++
+
+		pal := pals[4+int(attr&0x03)]
+
+		for py := 0; py < height; py++ {
+			for px := 0; px < 8; px++ {
+				if sx+px >= 256 || sy+py >= 240 {
+					continue
+				}
+
+				tx, ty := px, py
+				if attr&0x40 != 0 {
+					tx = 7 - tx
+				}
+				if attr&0x80 != 0 {
+					ty = height - 1 - ty
+				}
+
+				var tile *Tile
+				if ppu.TallSprites {
+					tile = tiles[(idx&0x01)*256+(idx&0xFE)+ty/8]
+				} else {
+					tile = tiles[ppu.SpriteTable*256+idx]
+				}
+
+				c := tile.ColorIndexAt(tx, ty%8)
+				if c == 0 {
+					continue
+				}
+
+				x := ((scroll.X+sx+px)%512 + 512) % 512
+				y := ((scroll.Y+sy+py)%480 + 480) % 480
+				if drawn[y*512+x] {
+					continue
+				}
+				drawn[y*512+x] = true
+
+				if attr&0x20 != 0 && opaque[y*512+x] {
+					continue
+				}
+				img.Set(x, y, pal[c])
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// SnesPpu holds dumps of the SNES PPU's memory along with the last value
+// written to each of its registers.
+type SnesPpu struct {
+	Vram  []byte // 64K
+	Cgram []byte // 512 bytes
+
+	// $2100 onward.  Only $2105-$210C are used.
+	Registers []byte
+}
+
+// Bit depth of each background layer in each mode.  Modes don't have the
+// layers past the end of their list.
+var snesLayerDepths = [8][]BitDepth{
+	{BD_2bpp, BD_2bpp, BD_2bpp, BD_2bpp},
+	{BD_4bpp, BD_4bpp, BD_2bpp},
+	{BD_4bpp, BD_4bpp},
+	{BD_8bpp, BD_4bpp},
+	{BD_8bpp, BD_2bpp},
+	{BD_4bpp, BD_2bpp},
+	{BD_4bpp},
+	{},
+}
+
+func (ppu *SnesPpu) reg(addr int) uint8 {
+	if addr-0x2100 >= len(ppu.Registers) {
+		return 0
+	}
+	return ppu.Registers[addr-0x2100]
+}
+
+// BgMode is the value of the lower three bits of $2105.
+func (ppu *SnesPpu) BgMode() int {
+	return int(ppu.reg(0x2105) & 0x07)
+}
+
+// LayerDepth returns the bit depth of background layer 1-4 in the current
+// mode.
+func (ppu *SnesPpu) LayerDepth(layer int) (BitDepth, error) {
+	if layer < 1 || layer > 4 {
+		return 0, fmt.Errorf("invalid layer %d", layer)
+	}
+
+	mode := ppu.BgMode()
+	if mode == 7 {
+		return 0, fmt.Errorf("mode 7 is not supported")
+	}
+
+	if layer > len(snesLayerDepths[mode]) {
+		return 0, fmt.Errorf("mode %d has no layer %d", mode, layer)
+	}
+	return snesLayerDepths[mode][layer-1], nil
+}
+
+// Layer draws the whole tilemap of background layer 1-4, one to four
+// screens of 32x32 tiles depending on the size set in $2107-$210A.
+func (ppu *SnesPpu) Layer(layer int) (image.Image, error) {
+	depth, err := ppu.LayerDepth(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ppu.Vram) != 0x10000 {
+		return nil, fmt.Errorf("VRAM is %d bytes; expected 65536", len(ppu.Vram))
+	}
+
+	if len(ppu.Cgram) != 512 {
+		return nil, fmt.Errorf("CGRAM is %d bytes; expected 512", len(ppu.Cgram))
+	}
+
+	planes, _ := depth.PlaneCount()
+	tileSize := planes * 8
+
+	sc := ppu.reg(0x2107 + layer - 1)
+	mapBase := int(sc>>2) << 11
+	screensX, screensY := 1+int(sc&0x01), 1+int(sc>>1)&0x01
+
+	nba := ppu.reg(0x210B + (layer-1)/2)
+	if layer%2 == 0 {
+		nba >>= 4
+	}
+	charBase := int(nba&0x0F) << 13
+
+	cs := CS_8x8
+	if ppu.reg(0x2105)&(0x08<<layer) != 0 {
+		cs = CS_16x16
+	}
+
+	pals, err := ppu.layerPalettes(layer, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := map[int]*Tile{}
+	tile := func(num int, pal color.Palette) (*Tile, error) {
+		num &= 0x3FF
+		t, ok := cache[num]
+		if !ok {
+			data := make([]byte, tileSize)
+			for i := range data {
+				data[i] = ppu.Vram[(charBase+num*tileSize+i)&0xFFFF]
+			}
+
+			t, err = CF_Snes.Decode(data, depth)
+			if err != nil {
+				return nil, err
+			}
+			cache[num] = t
+		}
+
+		c := *t
+		c.Palette = pal
+		return &c, nil
+	}
+
+	charW, charH := cs.XY()
+	img := image.NewRGBA(image.Rect(0, 0, screensX*32*charW, screensY*32*charH))
+
+	for s := 0; s < screensX*screensY; s++ {
+		tm, err := NewTilemap(cs, depth, pals)
+		if err != nil {
+			return nil, err
+		}
+		tm.CharacterSize = cs
+		tm.Palettes = pals
+
+		for i := 0; i < 32*32; i++ {
+			addr := (mapBase + s*0x800 + i*2) & 0xFFFF
+			entry := int(ppu.Vram[addr]) | int(ppu.Vram[(addr+1)&0xFFFF])<<8
+
+			num := entry & 0x3FF
+			palIdx := (entry >> 10) & 0x07
+			if palIdx >= len(pals) {
+				palIdx = 0
+			}
+			pal := pals[palIdx]
+
+			md := &tm.Tiles[i]
+			md.PaletteIdx = palIdx
+			md.Palette = pal
+			md.FlipHorizontal = entry&0x4000 != 0
+			md.FlipVertical = entry&0x8000 != 0
+
+			if cs == CS_8x8 {
+				md.Tile8, err = tile(num, pal)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			for j, offset := range []int{0, 1, 16, 17} {
+				md.Tile16[j], err = tile(num+offset, pal)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		origin := image.Pt((s%screensX)*32*charW, (s/screensX)*32*charH)
+		draw.Draw(img, tm.Bounds().Add(origin), tm.Image(), image.Point{}, draw.Src)
+	}
+
+	return img, nil
+}
+
+// layerPalettes returns the palettes a layer can use.  Color 0 of each is
+// transparent so the backdrop is used in its place.
+func (ppu *SnesPpu) layerPalettes(layer int, depth BitDepth) ([]color.Palette, error) {
+	colors := color.Palette{}
+	for i := 0; i < 256; i++ {
+		c, err := palette.SNES.Decode(ppu.Cgram[i*2:])
+		if err != nil {
+			return nil, err
+		}
+		colors = append(colors, c)
+	}
+
+	var base, size, count int
+	switch depth {
+	case BD_2bpp:
+		size, count = 4, 8
+
+		// Each layer gets its own 32 colors in mode 0.
+		if ppu.BgMode() == 0 {
+			base = (layer - 1) * 32
+		}
+	case BD_4bpp:
+		size, count = 16, 8
+	case BD_8bpp:
+		size, count = 256, 1
+	}
+
+	pals := []color.Palette{}
+	for p := 0; p < count; p++ {
+		pal := color.Palette{colors[0]}
+		pal = append(pal, colors[base+p*size+1:base+(p+1)*size]...)
+		pals = append(pals, pal)
+	}
+
+	return pals, nil
+}
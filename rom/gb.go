@@ -0,0 +1,226 @@
+package rom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	gbHeaderStart = 0x104
+	gbHeaderEnd   = 0x150
+
+	GbBankSize = 16 * 1024
+)
+
+// The boot ROM refuses to start a cartridge unless this is at 0x104.
+var gbLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83,
+	0x00, 0x0C, 0x00, 0x0D, 0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99, 0xBB, 0xBB, 0x67, 0x63,
+	0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+type CgbSupport int
+
+const (
+	CGB_None CgbSupport = iota
+	CGB_Compatible
+	CGB_Only
+)
+
+func (c CgbSupport) String() string {
+	switch c {
+	case CGB_None:
+		return "CGB_None"
+	case CGB_Compatible:
+		return "CGB_Compatible"
+	case CGB_Only:
+		return "CGB_Only"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Mapper chips by cartridge type.  Types not listed here have no mapper.
+var gbMappers = map[uint8]string{
+	0x01: "MBC1", 0x02: "MBC1", 0x03: "MBC1",
+	0x05: "MBC2", 0x06: "MBC2",
+	0x0B: "MMM01", 0x0C: "MMM01", 0x0D: "MMM01",
+	0x0F: "MBC3", 0x10: "MBC3", 0x11: "MBC3", 0x12: "MBC3", 0x13: "MBC3",
+	0x19: "MBC5", 0x1A: "MBC5", 0x1B: "MBC5", 0x1C: "MBC5", 0x1D: "MBC5", 0x1E: "MBC5",
+	0x20: "MBC6",
+	0x22: "MBC7",
+	0xFC: "Pocket Camera",
+	0xFD: "TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1",
+}
+
+// Number of 16K ROM banks by the value at 0x148.
+var gbRomBanks = map[uint8]int{
+	0x00: 2,
+	0x01: 4,
+	0x02: 8,
+	0x03: 16,
+	0x04: 32,
+	0x05: 64,
+	0x06: 128,
+	0x07: 256,
+	0x08: 512,
+	0x52: 72,
+	0x53: 80,
+	0x54: 96,
+}
+
+// RAM sizes in bytes by the value at 0x149.
+var gbRamSizes = map[uint8]int{
+	0x00: 0,
+	0x01: 2 * 1024,
+	0x02: 8 * 1024,
+	0x03: 32 * 1024,
+	0x04: 128 * 1024,
+	0x05: 64 * 1024,
+}
+
+// Gameboy is the cartridge header of a Game Boy or Game Boy Color ROM.
+type Gameboy struct {
+	Title string
+	Cgb   CgbSupport
+
+	CartridgeType uint8
+	RomBanks      int // 16K each, or zero for an unknown size
+	RamSize       int // In bytes
+
+	HeaderChecksum uint8
+
+	// The boot ROM also checks the header checksum, but a bad one doesn't
+	// stop the header from being read.
+	ChecksumOk bool
+
+	// Size of the ROM file in bytes.
+	RomSize int64
+}
+
+// ParseGameboy reads the cartridge header of a Game Boy ROM.  An error is
+// returned if the Nintendo logo isn't in the header.
+func ParseGameboy(r io.ReadSeeker) (*Gameboy, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Seek(gbHeaderStart, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, gbHeaderEnd-gbHeaderStart)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Game Boy header: %w", err)
+	}
+
+	// Offsets in the header are easier to follow as addresses.
+	at := func(addr int) []byte {
+		return header[addr-gbHeaderStart:]
+	}
+
+	if !bytes.Equal(at(0x104)[:len(gbLogo)], gbLogo) {
+		return nil, fmt.Errorf("no Game Boy header found")
+	}
+
+	h := &Gameboy{
+		CartridgeType:  at(0x147)[0],
+		RomBanks:       gbRomBanks[at(0x148)[0]],
+		RamSize:        gbRamSizes[at(0x149)[0]],
+		HeaderChecksum: at(0x14D)[0],
+		RomSize:        size,
+	}
+
+	// The last byte of the title became the CGB flag.  Later titles shrank
+	// further to make room for a four character manufacturer code, but
+	// nothing in the header says when, so it is left on the end of the
+	// title.
+	title := at(0x134)[:16]
+	switch at(0x143)[0] {
+	case 0x80:
+		h.Cgb = CGB_Compatible
+		title = title[:15]
+	case 0xC0:
+		h.Cgb = CGB_Only
+		title = title[:15]
+	}
+	h.Title = strings.TrimRight(string(title), " \x00")
+
+	var sum uint8
+	for _, b := range at(0x134)[:0x14D-0x134] {
+		sum = sum - b - 1
+	}
+	h.ChecksumOk = sum == h.HeaderChecksum
+
+	return h, nil
+}
+
+// Mapper returns the name of the cartridge's memory bank controller, or
+// "ROM" if it doesn't have one.
+func (h *Gameboy) Mapper() string {
+	if name, ok := gbMappers[h.CartridgeType]; ok {
+		return name
+	}
+	return "ROM"
+}
+
+// FileOffset translates a bank:address pair, eg "03:4A00", into a file
+// offset.  Bank 0 is always at 0000-3FFF and every other bank is switched
+// in at 4000-7FFF.  Bank 0 addresses up to 7FFF are accepted whatever the
+// cartridge type, and point at bank 1.
+func (h *Gameboy) FileOffset(addr string) (int64, error) {
+	parts := strings.Split(addr, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid Game Boy address %q; expected bank:address", addr)
+	}
+
+	bank, err := parseHex(parts[0])
+	if err != nil || bank < 0 {
+		return 0, fmt.Errorf("Invalid bank in Game Boy address %q", addr)
+	}
+
+	a, err := parseHex(parts[1])
+	if err != nil || a < 0 || a > 0x7FFF {
+		return 0, fmt.Errorf("Invalid address in Game Boy address %q", addr)
+	}
+
+	var offset int64
+	switch {
+	case bank == 0:
+		// This is deliberate, even with a mapper, so the first 32K can
+		// be written the way a ROM without one maps it.
+		offset = a
+	case a < GbBankSize:
+		return 0, fmt.Errorf("Game Boy address %q is in bank 0's range", addr)
+	default:
+		offset = bank*GbBankSize + a - GbBankSize
+	}
+
+	if offset >= h.RomSize {
+		return 0, fmt.Errorf("Game Boy address %q is past the end of the ROM", addr)
+	}
+
+	return offset, nil
+}
+
+// Address returns the bank:address pair of a file offset, eg "03:4A00".
+func (h *Gameboy) Address(offset int64) (string, error) {
+	if offset < 0 || offset >= h.RomSize {
+		return "", fmt.Errorf("offset 0x%X is not in ROM", offset)
+	}
+
+	bank := offset / GbBankSize
+	if bank == 0 {
+		return fmt.Sprintf("00:%04X", offset), nil
+	}
+
+	return fmt.Sprintf("%02X:%04X", bank, GbBankSize+offset%GbBankSize), nil
+}
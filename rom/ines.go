@@ -0,0 +1,235 @@
+package rom
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const inesMagic = "NES\x1A"
+
+const (
+	inesHeaderSize  = 16
+	inesTrainerSize = 512
+
+	PrgBankSize = 16 * 1024
+	ChrBankSize = 8 * 1024
+)
+
+type Mirroring int
+
+const (
+	MirrorHorizontal Mirroring = iota
+	MirrorVertical
+	MirrorFourScreen
+
+	// Set by the mapper at runtime, never in the header.
+	MirrorSingleLower
+	MirrorSingleUpper
+)
+
+// Nametable returns which 1K page of nametable RAM is used for each of the
+// four nametables at $2000, $2400, $2800, and $2C00.
+func (m Mirroring) Nametable(n int) int {
+	switch m {
+	case MirrorHorizontal:
+		return n / 2
+	case MirrorVertical:
+		return n % 2
+	case MirrorSingleLower:
+		return 0
+	case MirrorSingleUpper:
+		return 1
+	}
+	return n
+}
+
+func (m *Mirroring) UnmarshalText(b []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "horizontal", "h":
+		*m = MirrorHorizontal
+	case "vertical", "v":
+		*m = MirrorVertical
+	case "four", "fourscreen", "4":
+		*m = MirrorFourScreen
+	case "single0", "lower":
+		*m = MirrorSingleLower
+	case "single1", "upper":
+		*m = MirrorSingleUpper
+	default:
+		return fmt.Errorf("Invalid mirroring: %q", string(b))
+	}
+
+	return nil
+}
+
+func (m Mirroring) String() string {
+	switch m {
+	case MirrorHorizontal:
+		return "MirrorHorizontal"
+	case MirrorVertical:
+		return "MirrorVertical"
+	case MirrorFourScreen:
+		return "MirrorFourScreen"
+	case MirrorSingleLower:
+		return "MirrorSingleLower"
+	case MirrorSingleUpper:
+		return "MirrorSingleUpper"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// INes is the header of an iNES or NES 2.0 ROM.
+type INes struct {
+	Mapper    int
+	Submapper int // NES 2.0 only
+
+	// In bytes
+	PrgSize int
+	ChrSize int // Zero for CHR-RAM
+
+	Trainer   bool
+	Battery   bool
+	Mirroring Mirroring
+
+	Nes2 bool
+}
+
+func ParseINes(r io.Reader) (*INes, error) {
+	header := make([]byte, inesHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read iNES header: %w", err)
+	}
+
+	if string(header[0:4]) != inesMagic {
+		return nil, fmt.Errorf("missing iNES header")
+	}
+
+	h := &INes{
+		Trainer: header[6]&0x04 != 0,
+		Battery: header[6]&0x02 != 0,
+		Nes2:    header[7]&0x0C == 0x08,
+	}
+
+	switch {
+	case header[6]&0x08 != 0:
+		h.Mirroring = MirrorFourScreen
+	case header[6]&0x01 != 0:
+		h.Mirroring = MirrorVertical
+	default:
+		h.Mirroring = MirrorHorizontal
+	}
+
+	if h.Nes2 {
+		h.Mapper = int(header[6]>>4) | int(header[7]&0xF0) | int(header[8]&0x0F)<<8
+		h.Submapper = int(header[8] >> 4)
+		h.PrgSize = nes2RomSize(header[4], header[9]&0x0F, PrgBankSize)
+		h.ChrSize = nes2RomSize(header[5], header[9]>>4, ChrBankSize)
+		return h, nil
+	}
+
+	h.Mapper = int(header[6] >> 4)
+
+	// Old dumping tools wrote junk (eg "DiskDude!") over the end of the
+	// header, which includes the upper nibble of the mapper number.
+	if header[12] == 0 && header[13] == 0 && header[14] == 0 && header[15] == 0 {
+		h.Mapper |= int(header[7] & 0xF0)
+	}
+
+	h.PrgSize = int(header[4]) * PrgBankSize
+	h.ChrSize = int(header[5]) * ChrBankSize
+	return h, nil
+}
+
+// NES 2.0 sizes have four extra bits in byte 9.  If those are all set, the
+// LSB byte is an exponent and multiplier instead: EEEEEEMM.
+func nes2RomSize(lsb, msb uint8, bankSize int) int {
+	if msb == 0x0F {
+		exp := int(lsb >> 2)
+		mul := int(lsb&0x03)*2 + 1
+		return (1 << exp) * mul
+	}
+
+	return (int(msb)<<8 | int(lsb)) * bankSize
+}
+
+// PrgOffset is the file offset of the start of PRG-ROM.
+func (h *INes) PrgOffset() int64 {
+	offset := int64(inesHeaderSize)
+	if h.Trainer {
+		offset += inesTrainerSize
+	}
+	return offset
+}
+
+// ChrOffset is the file offset of the start of CHR-ROM.
+func (h *INes) ChrOffset() int64 {
+	return h.PrgOffset() + int64(h.PrgSize)
+}
+
+// ChrBanks is the number of 8K CHR-ROM banks.
+func (h *INes) ChrBanks() int {
+	return (h.ChrSize + ChrBankSize - 1) / ChrBankSize
+}
+
+// PrgBanks is the number of 16K PRG-ROM banks.
+func (h *INes) PrgBanks() int {
+	return (h.PrgSize + PrgBankSize - 1) / PrgBankSize
+}
+
+// FileOffset translates "chr:bank:offset" or "prg:bank:offset" into a file
+// offset.  CHR banks are 8K and PRG banks are 16K.  The offset is from the
+// start of the bank and can be omitted.
+func (h *INes) FileOffset(addr string) (int64, error) {
+	parts := strings.Split(addr, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("Invalid NES address %q; expected chr:bank:offset or prg:bank:offset", addr)
+	}
+
+	bank, err := parseNumber(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid bank in %q: %w", addr, err)
+	}
+
+	var offset int64
+	if len(parts) == 3 {
+		offset, err = parseNumber(parts[2])
+		if err != nil {
+			return 0, fmt.Errorf("Invalid offset in %q: %w", addr, err)
+		}
+	}
+
+	var start, bankSize, size int64
+	switch strings.ToLower(strings.TrimSpace(parts[0])) {
+	case "chr":
+		start, bankSize, size = h.ChrOffset(), ChrBankSize, int64(h.ChrSize)
+	case "prg":
+		start, bankSize, size = h.PrgOffset(), PrgBankSize, int64(h.PrgSize)
+	default:
+		return 0, fmt.Errorf("Invalid NES address %q; expected chr:bank:offset or prg:bank:offset", addr)
+	}
+
+	if bank < 0 || offset < 0 || offset >= bankSize || bank*bankSize+offset >= size {
+		return 0, fmt.Errorf("NES address %q is out of range", addr)
+	}
+
+	return start + bank*bankSize + offset, nil
+}
+
+// Address returns the "chr:bank:offset" or "prg:bank:offset" address of a
+// file offset.
+func (h *INes) Address(offset int64) (string, error) {
+	if offset >= h.PrgOffset() && offset < h.ChrOffset() {
+		rel := offset - h.PrgOffset()
+		return fmt.Sprintf("prg:%d:0x%04X", rel/PrgBankSize, rel%PrgBankSize), nil
+	}
+
+	if offset >= h.ChrOffset() && offset < h.ChrOffset()+int64(h.ChrSize) {
+		rel := offset - h.ChrOffset()
+		return fmt.Sprintf("chr:%d:0x%04X", rel/ChrBankSize, rel%ChrBankSize), nil
+	}
+
+	return "", fmt.Errorf("offset 0x%X is not in PRG-ROM or CHR-ROM", offset)
+}
@@ -0,0 +1,81 @@
+package rom
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// AddressMap translates addresses written in a system's own notation into
+// offsets in the ROM file.
+type AddressMap interface {
+	FileOffset(addr string) (int64, error)
+}
+
+// AddressLabeler names file offsets in a system's own notation.  The names
+// are accepted by the same type's FileOffset().
+type AddressLabeler interface {
+	Address(offset int64) (string, error)
+}
+
+// Detect looks for a known ROM header in r.  A nil AddressMap is returned
+// without an error if r isn't recognized.  r is left at the start of the
+// file.
+func Detect(r io.ReadSeeker) (AddressMap, error) {
+	defer r.Seek(0, io.SeekStart)
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, nil
+	}
+
+	if string(header) == inesMagic {
+		_, err = r.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		return ParseINes(r)
+	}
+
+	// The Game Boy logo is checked first since random data could pass the
+	// SNES header's checks.
+	if gb, err := ParseGameboy(r); err == nil {
+		return gb, nil
+	}
+
+	if snes, err := ParseSnes(r); err == nil {
+		return snes, nil
+	}
+
+	return nil, nil
+}
+
+// parseNumber parses decimal, 0x prefixed, or $ prefixed hexadecimal
+// numbers.
+func parseNumber(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "$") {
+		return strconv.ParseInt(s[1:], 16, 64)
+	}
+	return strconv.ParseInt(s, 0, 64)
+}
+
+// parseHex parses hexadecimal numbers with or without a $ or 0x prefix.
+func parseHex(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+
+	val, err := strconv.ParseInt(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid hex value %q", s)
+	}
+	return val, nil
+}
@@ -0,0 +1,233 @@
+package rom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type SnesMapping int
+
+const (
+	LoROM SnesMapping = iota
+	HiROM
+	ExHiROM
+)
+
+func (m SnesMapping) String() string {
+	switch m {
+	case LoROM:
+		return "LoROM"
+	case HiROM:
+		return "HiROM"
+	case ExHiROM:
+		return "ExHiROM"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+const snesCopierHeaderSize = 512
+
+// Location of the internal header in the ROM for each mapping.
+var snesHeaderOffsets = map[SnesMapping]int64{
+	LoROM:   0x007FC0,
+	HiROM:   0x00FFC0,
+	ExHiROM: 0x40FFC0,
+}
+
+// Snes is the internal header of a SNES ROM along with how the ROM is
+// mapped into the CPU's address space.
+type Snes struct {
+	Title   string
+	Mapping SnesMapping
+	MapMode uint8
+
+	Checksum   uint16
+	Complement uint16
+
+	// Size of the ROM data in bytes, not including any copier header.
+	RomSize int64
+
+	// Some copiers put a 512 byte header in front of the ROM data.
+	CopierHeader bool
+}
+
+// ParseSnes finds the internal header of a SNES ROM, skipping any copier
+// header, and works out the mapping from it.
+func ParseSnes(r io.ReadSeeker) (*Snes, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Snes{
+		CopierHeader: size%1024 == snesCopierHeaderSize,
+		RomSize:      size,
+	}
+
+	if h.CopierHeader {
+		h.RomSize -= snesCopierHeaderSize
+	}
+
+	bestScore := 0
+	for _, mapping := range []SnesMapping{LoROM, HiROM, ExHiROM} {
+		offset := snesHeaderOffsets[mapping]
+		if offset+0x40 > h.RomSize {
+			continue
+		}
+
+		if h.CopierHeader {
+			offset += snesCopierHeaderSize
+		}
+
+		_, err = r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		header := make([]byte, 0x40)
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return nil, err
+		}
+
+		score := scoreSnesHeader(header, mapping)
+		if score > bestScore {
+			bestScore = score
+			h.Mapping = mapping
+			h.MapMode = header[0x15]
+			h.Title = strings.TrimRight(string(header[0:21]), " \x00")
+			h.Complement = binary.LittleEndian.Uint16(header[0x1C:])
+			h.Checksum = binary.LittleEndian.Uint16(header[0x1E:])
+		}
+	}
+
+	if bestScore < snesMinScore {
+		return nil, fmt.Errorf("no SNES header found")
+	}
+
+	return h, nil
+}
+
+// A header needs a valid checksum pair and a map mode that agrees with its
+// location to be trusted.
+const snesMinScore = 6
+
+func scoreSnesHeader(header []byte, mapping SnesMapping) int {
+	score := 0
+
+	complement := binary.LittleEndian.Uint16(header[0x1C:])
+	checksum := binary.LittleEndian.Uint16(header[0x1E:])
+	if checksum^complement == 0xFFFF {
+		score += 4
+	}
+
+	mode := header[0x15] &^ 0x10 // ignore the FastROM bit
+	switch {
+	case mapping == LoROM && mode == 0x20,
+		mapping == HiROM && mode == 0x21,
+		mapping == ExHiROM && mode == 0x25:
+		score += 2
+	}
+
+	printable := true
+	for _, b := range header[0:21] {
+		if b != 0 && (b < 0x20 || b > 0x7E) {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		score++
+	}
+
+	// The reset vector always points into ROM.
+	if binary.LittleEndian.Uint16(header[0x3C:]) >= 0x8000 {
+		score++
+	}
+
+	return score
+}
+
+// FileOffset translates a CPU address, eg "$C8:1234" or "C8:1234", into a
+// file offset.
+func (h *Snes) FileOffset(addr string) (int64, error) {
+	parts := strings.Split(addr, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid SNES address %q; expected bank:address", addr)
+	}
+
+	bank, err := parseHex(parts[0])
+	if err != nil || bank < 0 || bank > 0xFF {
+		return 0, fmt.Errorf("Invalid bank in SNES address %q", addr)
+	}
+
+	a, err := parseHex(parts[1])
+	if err != nil || a < 0 || a > 0xFFFF {
+		return 0, fmt.Errorf("Invalid address in SNES address %q", addr)
+	}
+
+	var offset int64
+	switch h.Mapping {
+	case LoROM:
+		if a < 0x8000 && bank&0x7F < 0x40 {
+			return 0, fmt.Errorf("SNES address %q is not mapped to ROM", addr)
+		}
+		offset = (bank&0x7F)*0x8000 + a&0x7FFF
+
+	case HiROM, ExHiROM:
+		if a < 0x8000 && bank&0x40 == 0 {
+			return 0, fmt.Errorf("SNES address %q is not mapped to ROM", addr)
+		}
+		offset = (bank&0x3F)<<16 | a
+
+		// The second 4M of an ExHiROM is in the lower banks.
+		if h.Mapping == ExHiROM && bank < 0x80 {
+			offset += 0x400000
+		}
+	}
+
+	if offset >= h.RomSize {
+		return 0, fmt.Errorf("SNES address %q is past the end of the ROM", addr)
+	}
+
+	if h.CopierHeader {
+		offset += snesCopierHeaderSize
+	}
+
+	return offset, nil
+}
+
+// Address returns the CPU address of a file offset, eg "$C8:1234".
+func (h *Snes) Address(offset int64) (string, error) {
+	if h.CopierHeader {
+		offset -= snesCopierHeaderSize
+	}
+
+	if offset < 0 || offset >= h.RomSize {
+		return "", fmt.Errorf("offset 0x%X is not in ROM", offset)
+	}
+
+	var bank, a int64
+	switch h.Mapping {
+	case LoROM:
+		bank = offset / 0x8000
+		a = 0x8000 | offset%0x8000
+
+	case HiROM:
+		bank = 0xC0 | (offset>>16)&0x3F
+		a = offset & 0xFFFF
+
+	case ExHiROM:
+		if offset >= 0x400000 {
+			bank = 0x40 | ((offset-0x400000)>>16)&0x3F
+		} else {
+			bank = 0xC0 | (offset>>16)&0x3F
+		}
+		a = offset & 0xFFFF
+	}
+
+	return fmt.Sprintf("$%02X:%04X", bank, a), nil
+}
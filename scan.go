@@ -0,0 +1,457 @@
+package retroimg
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ScanOptions control which tile formats ScanChr() looks for.
+type ScanOptions struct {
+	Depths  []BitDepth
+	Formats []ChrFormat
+
+	// Number of tiles scored together.  Defaults to 16.
+	WindowTiles int
+
+	// Windows scoring at or above this are considered graphics.  Defaults
+	// to 0.3.
+	Threshold float64
+
+	// Regions shorter than this many tiles are dropped.  Defaults to 8.
+	MinTiles int
+}
+
+// ScanRegion is a run of data that looks like tiles of a single format.
+type ScanRegion struct {
+	Start  int64
+	Tiles  int
+	Depth  BitDepth
+	Format ChrFormat
+
+	// Average score of the windows in the region, from 0 to 1.
+	Score float64
+}
+
+// ScanResult holds the best score of every ScanStep bytes of the data along
+// with the regions found.
+type ScanResult struct {
+	Scores  []float64
+	Regions []ScanRegion
+}
+
+// Smallest tile size.  Every offset that is a multiple of this is scored.
+const ScanStep = 8
+
+type scanCombo struct {
+	depth  BitDepth
+	format ChrFormat
+	planes int
+
+	tileScores []float64
+	scores     []float64
+}
+
+// ScanChr slides over data and scores how much each window of tiles looks
+// like graphics for each of the given bit depths and formats.  Windows are
+// scored on the entropy of their bytes, how smooth their tiles look once
+// decoded, how well the edges in each tile's planes line up, and how often
+// a tile's rows repeat.  Code, text, and compressed data score close to
+// zero and so does blank space.
+func ScanChr(data []byte, opts ScanOptions) ScanResult {
+	if opts.WindowTiles <= 0 {
+		opts.WindowTiles = 16
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 0.3
+	}
+	if opts.MinTiles <= 0 {
+		opts.MinTiles = 8
+	}
+	if len(opts.Depths) == 0 {
+		opts.Depths = []BitDepth{BD_2bpp}
+	}
+	if len(opts.Formats) == 0 {
+		opts.Formats = []ChrFormat{CF_Nes}
+	}
+
+	steps := len(data) / ScanStep
+	combos := []*scanCombo{}
+	for _, depth := range opts.Depths {
+		planes, err := depth.PlaneCount()
+		if err != nil {
+			continue
+		}
+
+		for _, format := range opts.Formats {
+			// The formats are identical for 1bpp
+			if planes == 1 && format != opts.Formats[0] {
+				continue
+			}
+
+			tileScores := scoreTiles(data, format, planes)
+			combos = append(combos, &scanCombo{
+				depth:      depth,
+				format:     format,
+				planes:     planes,
+				tileScores: tileScores,
+				scores:     scoreWindows(data, tileScores, planes, opts.WindowTiles),
+			})
+		}
+	}
+
+	result := ScanResult{Scores: make([]float64, steps)}
+	for _, c := range combos {
+		for i, s := range c.scores {
+			if s > result.Scores[i] {
+				result.Scores[i] = s
+			}
+		}
+	}
+
+	result.Regions = findRegions(result.Scores, combos, opts)
+	return result
+}
+
+// scoreTiles returns the score of the tile starting at each step in data.
+func scoreTiles(data []byte, format ChrFormat, planes int) []float64 {
+	tileSize := planes * 8
+	steps := len(data) / ScanStep
+
+	scores := make([]float64, steps)
+	for i := 0; i < steps; i++ {
+		offset := i * ScanStep
+		if offset+tileSize > len(data) {
+			break
+		}
+		scores[i] = scoreTile(data[offset:offset+tileSize], format, planes)
+	}
+	return scores
+}
+
+// scoreWindows returns the score of the window of tiles starting at each
+// step in data.
+func scoreWindows(data []byte, tileScores []float64, planes, windowTiles int) []float64 {
+	tileSize := planes * 8
+	steps := len(data) / ScanStep
+
+	windowSize := tileSize * windowTiles
+	entropy := newSlidingEntropy()
+	scores := make([]float64, steps)
+
+	for i := 0; i < steps; i++ {
+		start := i * ScanStep
+		end := start + windowSize
+		if end > len(data) {
+			break
+		}
+
+		if i == 0 {
+			entropy.add(data[0:end])
+		} else {
+			entropy.remove(data[start-ScanStep : start])
+			entropy.add(data[end-ScanStep : end])
+		}
+
+		var structure float64
+		for t := 0; t < windowTiles; t++ {
+			structure += tileScores[i+t*tileSize/ScanStep]
+		}
+		structure /= float64(windowTiles)
+
+		scores[i] = structure * entropyScore(entropy.bits())
+	}
+
+	return scores
+}
+
+// Graphics usually sit in the middle of the entropy range.  Zero means the
+// data is blank and anything near eight bits is code or compressed.
+func entropyScore(e float64) float64 {
+	switch {
+	case e <= 0.5 || e >= 7.5:
+		return 0
+	case e < 1.5:
+		return e - 0.5
+	case e > 6.0:
+		return (7.5 - e) / 1.5
+	}
+	return 1
+}
+
+// scoreTile rates a single tile's structure from 0 to 1 by how often a pixel
+// matches its neighbours once decoded, and how many of its rows repeat the
+// one above.  Random data matches one time in as many colors as the depth
+// has, so that much is taken off the top.  Decoding with the wrong format or
+// depth mixes unrelated rows into each pixel and breaks up the shapes.
+// Blank tiles score zero so runs of padding aren't mistaken for graphics.
+func scoreTile(tile []byte, format ChrFormat, planes int) float64 {
+	blank := true
+	for _, b := range tile[1:] {
+		if b != tile[0] {
+			blank = false
+			break
+		}
+	}
+	if blank {
+		return 0
+	}
+
+	var pix [8][8]uint8
+	for p := 0; p < planes; p++ {
+		for y := 0; y < 8; y++ {
+			row := tile[format.offset(p, y, planes)]
+			for x := 0; x < 8; x++ {
+				pix[y][x] |= ((row >> (7 - x)) & 0x01) << p
+			}
+		}
+	}
+
+	// Neighbours that differ in the low planes, the high planes, and
+	// either of them.
+	half := uint8(1)<<(planes/2) - 1
+	var lo, hi, edges int
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			for _, n := range [][2]int{{y, x - 1}, {y - 1, x}} {
+				if n[0] < 0 || n[1] < 0 {
+					continue
+				}
+				diff := pix[y][x] ^ pix[n[0]][n[1]]
+				if diff&half != 0 {
+					lo++
+				}
+				if diff&^half != 0 {
+					hi++
+				}
+				if diff != 0 {
+					edges++
+				}
+			}
+		}
+	}
+
+	chance := 1 / float64(int(1)<<planes)
+	score := (float64(112-edges)/112 - chance) / (1 - chance)
+	if score < 0 {
+		return 0
+	}
+
+	// Flat areas and straight edges repeat whole rows of pixels, which
+	// almost never happens in random data or in planes decoded from
+	// unrelated rows, so each one raises the score.
+	repeated := 0
+	for y := 1; y < 8; y++ {
+		if pix[y] == pix[y-1] {
+			repeated++
+		}
+	}
+	score = math.Min(1, score*(1+0.5*float64(repeated)/7))
+
+	// The edges of a shape show up in all of its planes at once.  Planes
+	// from two different tiles, like 2bpp data read as 4bpp, have edges
+	// that only line up by chance.
+	// Flat high or low planes count as unrelated as well, otherwise a tile
+	// paired with a blank one looks just as good read at twice its depth.
+	if planes > 1 {
+		shared := 0.0
+		if lo > 0 && hi > 0 {
+			both := float64(lo + hi - edges)
+			expected := float64(lo*hi) / 112
+			shared = (both - expected) / (math.Min(float64(lo), float64(hi)) - expected)
+		}
+		score *= 0.5 + 0.5*math.Max(0, math.Min(1, shared))
+	}
+
+	return score
+}
+
+func findRegions(best []float64, combos []*scanCombo, opts ScanOptions) []ScanRegion {
+	regions := []ScanRegion{}
+
+	span := 0
+	for _, c := range combos {
+		if steps := opts.WindowTiles * c.planes * 8 / ScanStep; steps > span {
+			span = steps
+		}
+	}
+
+	i := 0
+	for i < len(best) {
+		if best[i] < opts.Threshold {
+			i++
+			continue
+		}
+
+		// Windows of different depths cover different lengths, so compare
+		// the tiles of each over the same stretch of data instead.  The
+		// window crosses the threshold before the first tile, so try each
+		// alignment too.
+		var combo *scanCombo
+		var bestMean float64
+		align := 0
+		for _, c := range combos {
+			tileSteps := c.planes * 8 / ScanStep
+			end := i + span
+			if end > len(c.tileScores) {
+				end = len(c.tileScores)
+			}
+
+			for phase := 0; phase < tileSteps && i+phase < end; phase++ {
+				var mean float64
+				count := 0
+				for j := i + phase; j < end; j += tileSteps {
+					mean += c.tileScores[j]
+					count++
+				}
+				mean /= float64(count)
+
+				if combo == nil || mean > bestMean {
+					combo = c
+					bestMean = mean
+					align = phase
+				}
+			}
+		}
+		i += align
+
+		// Follow the winning format a tile at a time.  Windows near the end
+		// of a region score lower, so the whole last window is included.
+		tileSteps := combo.planes * 8 / ScanStep
+		tiles := 0
+		var total float64
+		for j := i; j < len(best) && combo.scores[j] >= opts.Threshold; j += tileSteps {
+			total += combo.scores[j]
+			tiles++
+		}
+
+		if tiles == 0 {
+			i++
+			continue
+		}
+
+		count := tiles + opts.WindowTiles - 1
+		if maxTiles := (len(best) - i) / tileSteps; count > maxTiles {
+			count = maxTiles
+		}
+
+		// Windows overlapping the edges of a region still score well, so
+		// trim tiles from either end that don't look like graphics on their
+		// own.
+		start := i
+		for count > 0 && combo.tileScores[start] < opts.Threshold {
+			start += tileSteps
+			count--
+		}
+		for count > 0 && combo.tileScores[start+(count-1)*tileSteps] < opts.Threshold {
+			count--
+		}
+
+		if count >= opts.MinTiles {
+			regions = append(regions, ScanRegion{
+				Start:  int64(start * ScanStep),
+				Tiles:  count,
+				Depth:  combo.depth,
+				Format: combo.format,
+				Score:  total / float64(tiles),
+			})
+		}
+
+		if start+count*tileSteps > i {
+			i = start + count*tileSteps
+		} else {
+			i += tileSteps
+		}
+		continue
+	}
+
+	return regions
+}
+
+// ScanHeatmap draws the scores of a ScanResult, one pixel per ScanStep bytes
+// and width pixels per row, or 128 if width is less than 1.  Black is no
+// score, going through red and yellow to white for the highest.
+func ScanHeatmap(scores []float64, width int) image.Image {
+	if width < 1 {
+		width = 128
+	}
+
+	height := (len(scores) + width - 1) / width
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for i, s := range scores {
+		v := s * 3
+		img.Set(i%width, i/width, color.RGBA{
+			heatChannel(v),
+			heatChannel(v - 1),
+			heatChannel(v - 2),
+			0xFF,
+		})
+	}
+
+	return img
+}
+
+func heatChannel(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xFF
+	}
+	return uint8(v * 255)
+}
+
+// slidingEntropy keeps a byte histogram up to date as a window moves over
+// some data.  Keeping the sum of c*log2(c) means each byte in or out only
+// costs a couple of logs.
+type slidingEntropy struct {
+	counts [256]int
+	total  int
+	sum    float64
+}
+
+func newSlidingEntropy() *slidingEntropy {
+	return &slidingEntropy{}
+}
+
+func (se *slidingEntropy) update(b byte, delta int) {
+	c := se.counts[b]
+	se.sum -= clog(c)
+	c += delta
+	se.sum += clog(c)
+	se.counts[b] = c
+	se.total += delta
+}
+
+func (se *slidingEntropy) add(data []byte) {
+	for _, b := range data {
+		se.update(b, 1)
+	}
+}
+
+func (se *slidingEntropy) remove(data []byte) {
+	for _, b := range data {
+		se.update(b, -1)
+	}
+}
+
+// bits returns the Shannon entropy of the window in bits per byte.
+func (se *slidingEntropy) bits() float64 {
+	if se.total == 0 {
+		return 0
+	}
+	n := float64(se.total)
+	e := math.Log2(n) - se.sum/n
+	if e < 0 {
+		return 0
+	}
+	return e
+}
+
+func clog(c int) float64 {
+	if c <= 0 {
+		return 0
+	}
+	return float64(c) * math.Log2(float64(c))
+}
@@ -0,0 +1,390 @@
+package retroimg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/go-retroimg/compress"
+	"github.com/zorchenhimer/go-retroimg/palette"
+	"github.com/zorchenhimer/go-retroimg/rom"
+)
+
+type Segment struct {
+	Start int
+
+	// Start in the ROM's own notation, if it has a recognized header.
+	Label string
+
+	Depth BitDepth
+	Count int
+	Name string
+
+	// In tiles, not pixels
+	Width int
+	Height int
+
+	TileOrder  []int
+	Sequential bool
+
+	// Stride in Metatiles for the output image
+	Stride int
+
+	Format ChrFormat
+
+	// File offset of the colors to draw the segment with, or -1 for the
+	// default palette.
+	Palette int
+
+	// Codec the tiles are stored with, or nil for raw tiles.
+	Compression compress.Codec
+}
+
+func (s Segment) String() string {
+	compression := ""
+	if s.Compression != nil {
+		compression = " Compression:" + s.Compression.Name()
+	}
+
+	return fmt.Sprintf("{Segment Start:0x%X %s Count:0x%X (%d) Depth:%s TileOrder:%v%s}",
+		s.Start,
+		s.Label,
+		s.Count,
+		s.Count,
+		s.Depth,
+		s.TileOrder,
+		compression,
+	)
+}
+
+type CfgSegment struct {
+	// A file offset, or an address in the ROM's own notation when it has a
+	// recognized header (eg "chr:1:0x200" for an iNES ROM, "$C8:1234" for a
+	// SNES ROM, or "03:4A00" for a Game Boy ROM).
+	Start string
+	Depth int
+	Count string
+
+	// Image filename.  {addr}, {start}, {count}, and {bpp} are replaced with
+	// the segment's address in the ROM's notation, Start, Count, and Depth.
+	Name  string
+	Dimensions string // WxH: 1x1, 2x1, 1x3, 2x2, etc
+	TileOrder string // comma separated list of numbers
+	Sequential bool
+	Stride int // metatile count
+	Format string // nes, snes, or gb.  Defaults to gb for Game Boy ROMs and nes otherwise.
+
+	// Location of the segment's colors, written the same way as Start.  Read
+	// as NES color indexes for the nes format and BGR555 otherwise.
+	Palette string
+
+	// Name of the compression the tiles are stored with, eg "gba-lz77" or
+	// "lc-lz2".  The tiles are decompressed before they are read.
+	Compression string
+}
+
+// ParseSegmentConfig reads a JSON list of CfgSegment.  romMap is used to
+// translate Start values written as ROM addresses and can be nil for files
+// without a recognized header.  Problems that don't stop a segment from
+// being used, like a Start that can't be labeled in the ROM's notation, are
+// returned as warnings.
+func ParseSegmentConfig(filename string, romMap rom.AddressMap) ([]Segment, []error, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	cfg := []CfgSegment{}
+	dec := json.NewDecoder(file)
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	segments := []Segment{}
+	warnings := []error{}
+	for _, seg := range cfg {
+		start, err := parseAddress(seg.Start, romMap)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var palStart int64 = -1
+		if seg.Palette != "" {
+			palStart, err = parseAddress(seg.Palette, romMap)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		count, err := strconv.ParseInt(seg.Count, 0, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w, h := 1, 1
+
+		if seg.Dimensions != "" {
+			if !strings.Contains(seg.Dimensions, "x") {
+				return nil, nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
+			}
+
+			dims := strings.Split(seg.Dimensions, "x")
+			if len(dims) != 2 {
+				return nil, nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
+			}
+
+			w64, err := strconv.ParseInt(dims[0], 0, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
+			}
+
+			h64, err := strconv.ParseInt(dims[1], 0, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid dimension: %q", seg.Dimensions)
+			}
+
+			w, h = int(w64), int(h64)
+		}
+
+		tileCount := w*h
+		tileOrder := []int{}
+
+		if seg.TileOrder == "" {
+			for i := 0; i < tileCount; i++ {
+				tileOrder = append(tileOrder, i+1)
+			}
+		} else {
+			orderNums := strings.Split(seg.TileOrder, ",")
+			highest := 0
+
+			for _, str := range orderNums {
+				n, err := strconv.Atoi(str)
+				if err != nil {
+					return nil, nil, fmt.Errorf("Invalid TileOrder %q: %w", seg.TileOrder, err)
+				}
+
+				if n > highest {
+					highest = n
+				}
+				tileOrder = append(tileOrder, n)
+			}
+
+			// FIXME: do i care about repeated numbers?
+			if highest != len(tileOrder) {
+				return nil, nil, fmt.Errorf("Invalid TileOrder %q: bad length", seg.TileOrder)
+			}
+		}
+
+		depth := BD_2bpp
+		switch seg.Depth {
+		case 1:
+			depth = BD_1bpp
+		case 4:
+			depth = BD_4bpp
+		case 8:
+			depth = BD_8bpp
+		}
+
+		var format ChrFormat
+		if _, ok := romMap.(*rom.Gameboy); ok {
+			format = CF_Gb
+		}
+
+		if seg.Format != "" {
+			err = format.UnmarshalText([]byte(seg.Format))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		var codec compress.Codec
+		if seg.Compression != "" {
+			codec, err = compress.ByName(seg.Compression)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if count < 1 {
+			warnings = append(warnings, fmt.Errorf("ignoring segment at %s with a Count of %d", seg.Start, count))
+			continue
+		}
+
+		label := ""
+		if labeler, ok := romMap.(rom.AddressLabeler); ok {
+			label, err = labeler.Address(start)
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("segment at %s: %w", seg.Start, err))
+			}
+		}
+
+		seg.Name = strings.ReplaceAll(seg.Name, "{addr}", fileSafe(label))
+		seg.Name = strings.ReplaceAll(seg.Name, "{start}", seg.Start)
+		seg.Name = strings.ReplaceAll(seg.Name, "{count}", seg.Count)
+		seg.Name = strings.ReplaceAll(seg.Name, "{bpp}", strconv.Itoa(seg.Depth))
+
+		if seg.Stride == 0 {
+			seg.Stride = 16
+		}
+
+		segments = append(segments, Segment{
+			Start: int(start),
+			Label: label,
+			Depth: depth,
+			Count: int(count),
+			Name: seg.Name,
+			Width: w,
+			Height: h,
+			TileOrder: tileOrder,
+			Sequential: seg.Sequential,
+			Stride: seg.Stride,
+			Format: format,
+			Palette: int(palStart),
+			Compression: codec,
+		})
+	}
+
+	return segments, warnings, nil
+}
+
+// parseAddress turns a CfgSegment address into a file offset.
+func parseAddress(addr string, romMap rom.AddressMap) (int64, error) {
+	if !strings.Contains(addr, ":") {
+		return strconv.ParseInt(addr, 0, 32)
+	}
+
+	if romMap == nil {
+		return 0, fmt.Errorf("Address %q needs a ROM with a recognized header", addr)
+	}
+
+	return romMap.FileOffset(addr)
+}
+
+// fileSafe removes characters from addresses that aren't allowed in
+// filenames on some systems.
+func fileSafe(label string) string {
+	label = strings.ReplaceAll(label, "$", "")
+	return strings.ReplaceAll(label, ":", "_")
+}
+
+// Filename of the image for the segment at index num in the config.  Use
+// {addr} in the segment's Name to put its Label in the filename.
+func (s Segment) Filename(num int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return fmt.Sprintf("%04d_%05X.png", num, s.Start)
+}
+
+// Open returns a reader over the segment's tile data in r.  Compressed
+// segments are decompressed in full and the number of compressed bytes that
+// were read is returned.  It is zero for raw segments.
+func (s Segment) Open(r io.ReadSeeker) (io.ReadSeeker, int, error) {
+	_, err := r.Seek(int64(s.Start), io.SeekStart)
+	if err != nil {
+		return nil, 0, fmt.Errorf("seek error (%05X): %w", s.Start, err)
+	}
+
+	if s.Compression == nil {
+		return r, 0, nil
+	}
+
+	packed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, n, err := s.Compression.Decompress(packed)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s data at 0x%X: %w", s.Compression.Name(), s.Start, err)
+	}
+
+	return bytes.NewReader(data), n, nil
+}
+
+// MetaTiles is the number of metatiles of the segment in r.  It is Count
+// unless the data ends first, in which case the metatile cut short by the
+// end isn't counted.
+func (s Segment) MetaTiles(r io.ReadSeeker) (int, error) {
+	chr, _, err := s.Open(r)
+	if err != nil {
+		return 0, err
+	}
+
+	start, err := chr.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := chr.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	planeCount, err := s.Depth.PlaneCount()
+	if err != nil {
+		return 0, err
+	}
+
+	available := int(end-start) / (planeCount * 8 * s.Width * s.Height)
+	return min(s.Count, available), nil
+}
+
+// TileCount is the number of 8x8 tiles in the segment.
+func (s Segment) TileCount() int {
+	return s.Count * s.Width * s.Height
+}
+
+// SegmentDir is the default directory for the images of the segments
+// extracted from romfile.
+func SegmentDir(romfile string) string {
+	idx := strings.LastIndex(romfile, ".")
+	if idx < 1 {
+		return romfile+"_output"
+	}
+	return romfile[:idx]
+}
+
+// ReadPalette reads count colors from the segment's Palette offset in r.  NES
+// segments use color indexes and everything else uses BGR555.
+func (s Segment) ReadPalette(r io.ReadSeeker, count int) (color.Palette, error) {
+	_, err := r.Seek(int64(s.Palette), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 2
+	if s.Format == CF_Nes {
+		size = 1
+	}
+
+	data := make([]byte, count*size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	pal := color.Palette{}
+	for i := 0; i < count; i++ {
+		if s.Format == CF_Nes {
+			pal = append(pal, palette.Nes_2C02[fmt.Sprintf("%02x", data[i]&0x3F)])
+			continue
+		}
+
+		c, err := palette.SNES.Decode(data[i*2:])
+		if err != nil {
+			return nil, err
+		}
+		pal = append(pal, c)
+	}
+
+	return pal, nil
+}
@@ -0,0 +1,70 @@
+package retroimg
+
+import (
+	"fmt"
+)
+
+// ScreenRegion is a band of rows in a screen that uses its own set of tiles,
+// eg. its own pattern table or CHR bank.
+type ScreenRegion struct {
+	// First row of the region, in tiles, and the scanline it starts on.
+	Row      int
+	Rows     int
+	Scanline int
+
+	Tiles TileList
+
+	// Index into Tiles of each tile in the region, row by row.
+	TileIds []int
+}
+
+// SplitRows splits the image into horizontal regions of whole rows of tiles,
+// each using at most maxTiles unique tiles.  Each region is made as tall as
+// it can be before a new one is started.
+func (ti *TiledImage) SplitRows(maxTiles int) ([]ScreenRegion, error) {
+	width, height := ti.CharacterSize.XY()
+	cols := ti.bounds.Dx() / width
+	rows := ti.bounds.Dy() / height
+
+	regions := []ScreenRegion{}
+	var cur *ScreenRegion
+	var ids map[string]int
+
+	for row := 0; row < rows; row++ {
+		line := ti.Tiles[row*cols : (row+1)*cols]
+
+		// Tiles this row adds to the current region.
+		added := map[string]bool{}
+		for _, tile := range line {
+			if _, ok := ids[tile.Hash()]; !ok {
+				added[tile.Hash()] = true
+			}
+		}
+
+		if cur == nil || len(cur.Tiles)+len(added) > maxTiles {
+			regions = append(regions, ScreenRegion{
+				Row:      row,
+				Scanline: row * height,
+			})
+			cur = &regions[len(regions)-1]
+			ids = map[string]int{}
+		}
+
+		for _, tile := range line {
+			id, ok := ids[tile.Hash()]
+			if !ok {
+				id = len(cur.Tiles)
+				ids[tile.Hash()] = id
+				cur.Tiles = append(cur.Tiles, tile)
+			}
+			cur.TileIds = append(cur.TileIds, id)
+		}
+		cur.Rows++
+
+		if len(cur.Tiles) > maxTiles {
+			return nil, fmt.Errorf("row %d has more than %d unique tiles", row, maxTiles)
+		}
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,282 @@
+package retroimg
+
+import (
+	"fmt"
+)
+
+// Stripe is a run of writes to PPU memory in the format used by many
+// Nintendo games:
+//
+//	AAAAAAAA aaaaaaaa VRLLLLLL data...
+//
+// A is the high byte of the address and a the low byte.  V set increments
+// the address by 32 after each byte, writing down a column of a nametable.
+// R set repeats a single data byte L times, otherwise L bytes follow.  A
+// length of zero means 64.  A buffer of stripes ends with a byte that has
+// bit 7 set.
+type Stripe struct {
+	Address  uint16
+	Vertical bool
+	Repeat   bool
+
+	// Every byte written, even when Repeat is set.
+	Data []byte
+}
+
+const (
+	StripeEnd       = 0xFF
+	StripeMaxLength = 64
+
+	// NTSC vblank is about 2273 CPU cycles, less the 513 of an OAM DMA.
+	NtscVblankCycles = 2273 - 513
+
+	// Rough cost of a typical update loop: setting the address and control
+	// register for each stripe, then a load, store, and branch per byte.
+	// Repeated bytes skip the load.
+	stripeCycles  = 40
+	literalCycles = 15
+	repeatCycles  = 9
+)
+
+// Bytes returns the stripe as it is stored in a buffer.
+func (s Stripe) Bytes() []byte {
+	flags := byte(len(s.Data) & 0x3F)
+	if s.Vertical {
+		flags |= 0x80
+	}
+
+	if s.Repeat {
+		return []byte{byte(s.Address >> 8), byte(s.Address), flags | 0x40, s.Data[0]}
+	}
+
+	return append([]byte{byte(s.Address >> 8), byte(s.Address), flags}, s.Data...)
+}
+
+// Cycles is an estimate of the CPU cycles it takes to write the stripe.
+func (s Stripe) Cycles() int {
+	if s.Repeat {
+		return stripeCycles + len(s.Data)*repeatCycles
+	}
+	return stripeCycles + len(s.Data)*literalCycles
+}
+
+func (s Stripe) String() string {
+	dir := "horizontal"
+	if s.Vertical {
+		dir = "vertical"
+	}
+
+	kind := "literal"
+	if s.Repeat {
+		kind = "repeat"
+	}
+
+	return fmt.Sprintf("{Stripe $%04X %s %s %d bytes}", s.Address, dir, kind, len(s.Data))
+}
+
+// StripeBuffer joins stripes into a buffer, ending it with StripeEnd.
+func StripeBuffer(stripes []Stripe) []byte {
+	buf := []byte{}
+	for _, s := range stripes {
+		buf = append(buf, s.Bytes()...)
+	}
+	return append(buf, StripeEnd)
+}
+
+// StripeCycles is the estimated CPU cycles to write all of the stripes.
+func StripeCycles(stripes []Stripe) int {
+	cycles := 0
+	for _, s := range stripes {
+		cycles += s.Cycles()
+	}
+	return cycles
+}
+
+// SplitStripeFrames splits stripes into frames that each take at most
+// maxCycles to write.  A stripe that takes longer than that on its own gets
+// a frame to itself.
+func SplitStripeFrames(stripes []Stripe, maxCycles int) [][]Stripe {
+	frames := [][]Stripe{}
+	cycles := 0
+	for _, s := range stripes {
+		if len(frames) == 0 || cycles+s.Cycles() > maxCycles {
+			frames = append(frames, []Stripe{})
+			cycles = 0
+		}
+
+		frames[len(frames)-1] = append(frames[len(frames)-1], s)
+		cycles += s.Cycles()
+	}
+	return frames
+}
+
+// A gap of unchanged bytes shorter than a stripe header is cheaper to
+// rewrite than to start a new stripe after.
+const stripeMaxGap = 3
+
+// DiffStripes returns the stripes that turn old into new, where both are
+// written starting at address.  Vertical stripes down the columns of a
+// nametable are only used if vertical is set, and address must then be the
+// start of a nametable.  Runs are picked greedily, covering the most changed
+// bytes first, and runs of a single value are written as repeats.
+func DiffStripes(old, new []byte, address uint16, vertical bool) ([]Stripe, error) {
+	if len(old) != len(new) {
+		return nil, fmt.Errorf("Data sizes differ: %d and %d bytes", len(old), len(new))
+	}
+
+	if vertical && address&0x3FF != 0 {
+		return nil, fmt.Errorf("Vertical stripes need data starting at a nametable, not $%04X", address)
+	}
+
+	pending := make([]bool, len(new))
+	left := 0
+	for i := range new {
+		if old[i] != new[i] {
+			pending[i] = true
+			left++
+		}
+	}
+
+	type run struct {
+		start, step, length, covers int
+	}
+
+	// measure follows changes from start, bridging short gaps, and returns
+	// the run that covers the most of them.
+	measure := func(start, step int) run {
+		r := run{start: start, step: step}
+		length, covers, gap := 0, 0, 0
+		for i := start; i < len(new) && length < StripeMaxLength; i += step {
+			// Vertical runs stop at the attribute table.
+			if step > 1 && (i/0x400 != start/0x400 || i%0x400 >= 30*32) {
+				break
+			}
+
+			length++
+			if !pending[i] {
+				gap++
+				if gap > stripeMaxGap {
+					break
+				}
+				continue
+			}
+
+			gap = 0
+			covers++
+			r.length, r.covers = length, covers
+		}
+		return r
+	}
+
+	runs := []run{}
+	for left > 0 {
+		best := run{}
+		for i := range new {
+			if !pending[i] {
+				continue
+			}
+
+			r := measure(i, 1)
+			if r.covers > best.covers {
+				best = r
+			}
+
+			if vertical {
+				r = measure(i, 32)
+				if r.covers > best.covers {
+					best = r
+				}
+			}
+		}
+
+		for n := 0; n < best.length; n++ {
+			i := best.start + n*best.step
+			if pending[i] {
+				pending[i] = false
+				left--
+			}
+		}
+		runs = append(runs, best)
+	}
+
+	stripes := []Stripe{}
+	for _, r := range runs {
+		data := []byte{}
+		for n := 0; n < r.length; n++ {
+			data = append(data, new[r.start+n*r.step])
+		}
+
+		stripes = append(stripes, splitRepeats(Stripe{
+			Address:  address + uint16(r.start),
+			Vertical: r.step > 1,
+			Data:     data,
+		})...)
+	}
+
+	return stripes, nil
+}
+
+// Repeated bytes are split into their own stripe when there are at least
+// this many.  A repeat saves a byte for each one past the first, but a
+// split can add two stripe headers.
+const stripeMinRepeat = 8
+
+// splitRepeats splits long runs of a single value out of a stripe.
+func splitRepeats(s Stripe) []Stripe {
+	step := uint16(1)
+	if s.Vertical {
+		step = 32
+	}
+
+	if runLength(s.Data) == len(s.Data) && len(s.Data) > 1 {
+		s.Repeat = true
+		return []Stripe{s}
+	}
+
+	stripes := []Stripe{}
+	start := 0
+	for i := 0; i < len(s.Data); {
+		n := runLength(s.Data[i:])
+		if n < stripeMinRepeat {
+			i += n
+			continue
+		}
+
+		if i > start {
+			stripes = append(stripes, Stripe{
+				Address:  s.Address + uint16(start)*step,
+				Vertical: s.Vertical,
+				Data:     s.Data[start:i],
+			})
+		}
+
+		stripes = append(stripes, Stripe{
+			Address:  s.Address + uint16(i)*step,
+			Vertical: s.Vertical,
+			Repeat:   true,
+			Data:     s.Data[i : i+n],
+		})
+
+		i += n
+		start = i
+	}
+
+	if start < len(s.Data) {
+		stripes = append(stripes, Stripe{
+			Address:  s.Address + uint16(start)*step,
+			Vertical: s.Vertical,
+			Data:     s.Data[start:],
+		})
+	}
+
+	return stripes
+}
+
+// runLength counts how many times the first byte repeats.
+func runLength(data []byte) int {
+	n := 0
+	for n < len(data) && data[n] == data[0] {
+		n++
+	}
+	return n
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"hash/crc32"
 	"bytes"
+
+	"github.com/zorchenhimer/go-retroimg/export"
 )
 
 // Tiles are always 8x8 pixels.
@@ -158,8 +160,44 @@ func (tile *Tile) binary() []byte {
 type TileList []*Tile
 
 func (tl TileList) WriteChr(w io.Writer) error {
+	return tl.WriteChrFormat(w, CF_Nes)
+}
+
+// CArray returns the tiles as a C array called name, with a macro for the
+// tile count.
+func (tl TileList) CArray(name string, format ChrFormat) export.CArray {
+	blocks := [][]byte{}
+	for _, tile := range tl {
+		blocks = append(blocks, format.Encode(tile))
+	}
+
+	return export.CArray{
+		Table: export.Table{
+			Name:   name,
+			Blocks: blocks,
+			Comment: func(i int) string {
+				return fmt.Sprintf("tile %d", i)
+			},
+		},
+		Defines: []export.Define{{Name: "TILE_COUNT", Value: len(tl)}},
+	}
+}
+
+// Asset returns the tiles as an asset called name.  If it's larger than a
+// bank, it's only split across banks at tile boundaries.
+func (tl TileList) Asset(name string, format ChrFormat) export.Asset {
+	asset := export.Asset{Name: name}
+	for _, tile := range tl {
+		data := format.Encode(tile)
+		asset.Data = append(asset.Data, data...)
+		asset.Align = len(data)
+	}
+	return asset
+}
+
+func (tl TileList) WriteChrFormat(w io.Writer, format ChrFormat) error {
 	for _, tile := range tl {
-		_, err := w.Write(tile.binary())
+		_, err := w.Write(format.Encode(tile))
 		if err != nil {
 			return err
 		}
@@ -40,7 +40,27 @@ func NewTileMetadata(cs CharSize, depth BitDepth, pal color.Palette) TileMetadat
 	return tm
 }
 
+// flip moves x and y to where they are in the tile data when the tile is
+// drawn flipped.  16x16 and 16x8 tiles are flipped as a whole.
+func (tm *TileMetadata) flip(x, y int) (int, int) {
+	width, height := 8, 8
+	if tm.Tile16 != nil {
+		width, height = 16, 16
+	} else if tm.TileWide != nil {
+		width = 16
+	}
+
+	if tm.FlipHorizontal {
+		x = width - 1 - x
+	}
+	if tm.FlipVertical {
+		y = height - 1 - y
+	}
+	return x, y
+}
+
 func (tm *TileMetadata) At(x, y int) color.Color {
+	x, y = tm.flip(x, y)
 	if tm.Tile8 != nil {
 		return tm.Tile8.At(x, y)
 	}
@@ -61,6 +81,7 @@ func (tm *TileMetadata) At(x, y int) color.Color {
 }
 
 func (tm *TileMetadata) ColorIndexAt(x, y int) uint8 {
+	x, y = tm.flip(x, y)
 	if tm.Tile8 != nil {
 		return tm.Tile8.ColorIndexAt(x, y)
 	}
@@ -0,0 +1,349 @@
+package retroimg
+
+import (
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// PatternHash returns a hash of the tile's pixels with the color indexes
+// renumbered in the order they first appear.  Tiles with the same shape but
+// different palettes, like a screenshot and the CHR it came from, have the
+// same pattern hash.
+func (tile *Tile) PatternHash() string {
+	key, _ := patternKey(tile.Pix)
+	return fmt.Sprintf("%08X", key)
+}
+
+// patternKey returns the pattern hash of 64 color indexes along with the
+// number of colors used.
+func patternKey(pix []uint8) (uint32, int) {
+	var relabel [256]uint8
+	var seen [256]bool
+	var buf [64]uint8
+
+	next := 0
+	for i, p := range pix {
+		if !seen[p] {
+			seen[p] = true
+			relabel[p] = uint8(next)
+			next++
+		}
+		buf[i] = relabel[p]
+	}
+
+	return crc32.ChecksumIEEE(buf[:len(pix)]), next
+}
+
+// PatternTiles cuts img into 8x8 tiles starting at origin, for use with
+// FindTiles().  The colors of each tile are numbered in the order they first
+// appear so any image will do, including screenshots.  Partial tiles at the
+// right and bottom edges are dropped.  The width of the grid in tiles is
+// returned along with the tiles.
+func PatternTiles(img image.Image, origin image.Point) ([]*Tile, int, error) {
+	bounds := img.Bounds()
+	width := (bounds.Max.X - origin.X) / 8
+	height := (bounds.Max.Y - origin.Y) / 8
+	if width < 1 || height < 1 {
+		return nil, 0, fmt.Errorf("image is smaller than a tile")
+	}
+
+	tiles := []*Tile{}
+	for ty := 0; ty < height; ty++ {
+		for tx := 0; tx < width; tx++ {
+			lookup := map[color.RGBA]uint8{}
+			pal := color.Palette{}
+			pix := make([]uint8, 64)
+
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					c := color.RGBAModel.Convert(img.At(origin.X+tx*8+x, origin.Y+ty*8+y)).(color.RGBA)
+					idx, ok := lookup[c]
+					if !ok {
+						if len(pal) == 256 {
+							return nil, 0, fmt.Errorf("tile %d, %d has more than 256 colors", tx, ty)
+						}
+						idx = uint8(len(pal))
+						lookup[c] = idx
+						pal = append(pal, c)
+					}
+					pix[y*8+x] = idx
+				}
+			}
+
+			var depth BitDepth
+			switch {
+			case len(pal) <= 2:
+				depth = BD_1bpp
+			case len(pal) <= 4:
+				depth = BD_2bpp
+			case len(pal) <= 16:
+				depth = BD_4bpp
+			default:
+				depth = BD_8bpp
+			}
+
+			tile := NewTile(depth, pal)
+			copy(tile.Pix, pix)
+			tiles = append(tiles, tile)
+		}
+	}
+
+	return tiles, width, nil
+}
+
+// TileSearchOptions control which tile formats FindTiles() looks for.
+type TileSearchOptions struct {
+	Depths  []BitDepth
+	Formats []ChrFormat
+
+	// Runs shorter than this many tiles are dropped.  Defaults to 2.
+	MinTiles int
+}
+
+// TileMatch is a run of tiles in the data that match tiles from the image,
+// one after the other.
+type TileMatch struct {
+	Offset int64
+	Depth  BitDepth
+	Format ChrFormat
+
+	// Index of the matching image tile for each tile in the run.  Tiles
+	// that appear more than once in the image are matched to the one that
+	// follows on from the previous tile.
+	Tiles []int
+
+	// Every image tile each tile in the run matches.
+	Candidates [][]int
+}
+
+// FindTiles looks for the tiles of an image in data at every byte offset.
+// Tiles are compared by their PatternHash() so the palette doesn't need to
+// be known.  Tiles with a single color match almost anything so runs can
+// pass through them but never start or end with them.
+func FindTiles(data []byte, tiles []*Tile, opts TileSearchOptions) []TileMatch {
+	if opts.MinTiles <= 0 {
+		opts.MinTiles = 2
+	}
+	if len(opts.Depths) == 0 {
+		opts.Depths = []BitDepth{BD_2bpp}
+	}
+	if len(opts.Formats) == 0 {
+		opts.Formats = []ChrFormat{CF_Nes}
+	}
+
+	patterns := map[uint32][]int{}
+	for i, t := range tiles {
+		key, _ := patternKey(t.Pix)
+		patterns[key] = append(patterns[key], i)
+	}
+
+	matches := []TileMatch{}
+	for _, depth := range opts.Depths {
+		planes, err := depth.PlaneCount()
+		if err != nil {
+			continue
+		}
+
+		for _, format := range opts.Formats {
+			// The formats are identical for 1bpp
+			if planes == 1 && format != opts.Formats[0] {
+				continue
+			}
+
+			matches = append(matches, findTileRuns(data, patterns, depth, format, planes, opts.MinTiles)...)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Offset < matches[j].Offset
+	})
+
+	return matches
+}
+
+func findTileRuns(data []byte, patterns map[uint32][]int, depth BitDepth, format ChrFormat, planes, minTiles int) []TileMatch {
+	tileSize := planes * 8
+	if len(data) < tileSize {
+		return nil
+	}
+
+	// Image tiles that match the data at each offset.
+	hits := make([][]int, len(data)-tileSize+1)
+	blank := make([]bool, len(hits))
+	pix := make([]uint8, 64)
+	for offset := range hits {
+		tile := data[offset : offset+tileSize]
+		for i := range pix {
+			pix[i] = 0
+		}
+
+		for p := 0; p < planes; p++ {
+			for y := 0; y < 8; y++ {
+				row := tile[format.offset(p, y, planes)]
+				for x := 0; x < 8; x++ {
+					pix[y*8+x] |= ((row >> (7 - x)) & 0x01) << p
+				}
+			}
+		}
+
+		key, colors := patternKey(pix)
+		hits[offset] = patterns[key]
+		blank[offset] = colors < 2
+	}
+
+	matches := []TileMatch{}
+	used := make([]bool, len(hits))
+	for offset := range hits {
+		if used[offset] || hits[offset] == nil || blank[offset] {
+			continue
+		}
+
+		// A tile that shows up more than once in the image could be any of
+		// them, so pick the one that follows on from the previous tile.
+		run := []int{hits[offset][0]}
+		candidates := [][]int{hits[offset]}
+		used[offset] = true
+		for next := offset + tileSize; next < len(hits) && hits[next] != nil; next += tileSize {
+			prev := run[len(run)-1]
+			pick := hits[next][0]
+			for _, idx := range hits[next] {
+				if abs(idx-prev-1) < abs(pick-prev-1) {
+					pick = idx
+				}
+			}
+
+			run = append(run, pick)
+			candidates = append(candidates, hits[next])
+			used[next] = true
+		}
+
+		for blank[offset+(len(run)-1)*tileSize] {
+			run = run[:len(run)-1]
+			candidates = candidates[:len(candidates)-1]
+		}
+
+		if len(run) < minTiles {
+			continue
+		}
+
+		matches = append(matches, TileMatch{
+			Offset:     int64(offset),
+			Depth:      depth,
+			Format:     format,
+			Tiles:      run,
+			Candidates: candidates,
+		})
+	}
+
+	return matches
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Metatile sizes tried by TileOrderHint(), smallest first.
+var hintSizes = [][2]int{{1, 2}, {2, 1}, {2, 2}, {4, 2}, {2, 4}, {4, 4}}
+
+// Fraction of a match that has to agree with a tile order for
+// TileOrderHint() to suggest it.
+const hintAgreement = 0.75
+
+// TileOrderHint works out how the tiles of a match are arranged in an image
+// width tiles wide.  Tiles stored in the same order as the image give 1x1.
+// Otherwise it tries metatile sizes where each group of tiles in the match
+// covers a box in the image in the same order, like 8x16 sprites.  Tiles that
+// match more than one place in the image are left out and most of what's
+// left has to agree.  The order is in the form of Segment.TileOrder.  ok is
+// false if no arrangement fits.
+func TileOrderHint(match TileMatch, width int) (w, h int, order []int, ok bool) {
+	unique := func(i int) bool {
+		return len(match.Candidates[i]) == 1
+	}
+
+	var steps, total int
+	for i := 1; i < len(match.Tiles); i++ {
+		if !unique(i) || !unique(i-1) {
+			continue
+		}
+		total++
+		if match.Tiles[i] == match.Tiles[i-1]+1 {
+			steps++
+		}
+	}
+
+	var best float64
+	if total > 0 {
+		best = float64(steps) / float64(total)
+	}
+	w, h, order = 1, 1, []int{1}
+
+	for _, size := range hintSizes {
+		count := size[0] * size[1]
+
+		groups := 0
+		votes := map[string]int{}
+		orders := map[string][]int{}
+	group:
+		for start := 0; start+count <= len(match.Tiles); start += count {
+			for i := start; i < start+count; i++ {
+				if !unique(i) {
+					continue group
+				}
+			}
+			groups++
+
+			o := boxOrder(match.Tiles[start:start+count], size[0], size[1], width)
+			if o == nil {
+				continue
+			}
+			key := fmt.Sprint(o)
+			votes[key]++
+			orders[key] = o
+		}
+
+		for key, v := range votes {
+			if frac := float64(v) / float64(groups); frac > best {
+				best = frac
+				w, h, order = size[0], size[1], orders[key]
+			}
+		}
+	}
+
+	if best < hintAgreement {
+		return 0, 0, nil, false
+	}
+	return w, h, order, true
+}
+
+// boxOrder returns the Segment.TileOrder that puts tiles into a w x h box in
+// an image width tiles wide, or nil if they don't make a box.
+func boxOrder(tiles []int, w, h, width int) []int {
+	minX, minY := width, -1
+	for _, t := range tiles {
+		x, y := t%width, t/width
+		if x < minX {
+			minX = x
+		}
+		if minY == -1 || y < minY {
+			minY = y
+		}
+	}
+
+	order := make([]int, w*h)
+	for i, t := range tiles {
+		x, y := t%width-minX, t/width-minY
+		if x >= w || y >= h || order[y*w+x] != 0 {
+			return nil
+		}
+		order[y*w+x] = i + 1
+	}
+
+	return order
+}